@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 
 	"github.com/joho/godotenv"
 	"github.com/thineshsubramani/sync-ssh-id/internal/cli"
@@ -10,6 +12,20 @@ import (
 func main() {
 	_ = godotenv.Load() // load global .env silently
 
+	// subcommands take their own flags
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		if err := cli.RunSign(os.Args[2:]); err != nil {
+			log.Fatalf("[sign] error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		if err := cli.RunKeygen(os.Args[2:]); err != nil {
+			log.Fatalf("[keygen] error: %v", err)
+		}
+		return
+	}
+
 	opts := cli.ParseFlags() // parse args & flags
 
 	switch {
@@ -18,7 +34,12 @@ func main() {
 			log.Fatalf("[interactive] error: %v", err)
 		}
 	default:
+		// exit 1 on failures, 3 when every host was reachable but some drifted (check action)
 		if err := cli.RunInventory(opts); err != nil {
+			if errors.Is(err, cli.ErrDrift) {
+				log.Printf("[inventory] %v", err)
+				os.Exit(3)
+			}
 			log.Fatalf("[inventory] error: %v", err)
 		}
 	}
@@ -6,9 +6,13 @@ toolchain go1.23.11
 
 require (
 	github.com/joho/godotenv v1.5.1
+	github.com/pkg/sftp v1.13.9
 	golang.org/x/crypto v0.40.0
 	golang.org/x/term v0.33.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.34.0 // indirect
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
+)
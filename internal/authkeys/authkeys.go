@@ -0,0 +1,271 @@
+package authkeys
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Entry is a single line of an authorized_keys file.
+// Blank lines, comments and lines that fail to parse are kept as entries with a nil Key
+// so that the file can be written back without losing anything.
+type Entry struct {
+	Line    int           // 1-based line number in the parsed input
+	Raw     string        // original line, without the trailing newline
+	Options []string      // leading options, e.g. `from="10.0.0.0/8"`, `no-pty`
+	Type    string        // key type, e.g. ssh-ed25519
+	Blob    []byte        // wire-format public key
+	Comment string        // trailing comment, often user@host
+	Key     ssh.PublicKey // parsed key; nil for non-key lines
+	Err     error         // why a non-blank, non-comment line could not be parsed
+}
+
+// IsKey reports whether the entry holds a public key.
+func (e Entry) IsKey() bool {
+	return e.Key != nil
+}
+
+// Fingerprint returns the SHA256 fingerprint of the key ("" for non-key lines).
+func (e Entry) Fingerprint() string {
+	if e.Key == nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+// Bits returns the key size in bits (0 if unknown).
+func (e Entry) Bits() int {
+	if e.Key == nil {
+		return 0
+	}
+	switch e.Type {
+	case ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519:
+		return 256
+	case ssh.KeyAlgoSKECDSA256:
+		return 256
+	}
+	ck, ok := e.Key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch pk := ck.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return pk.N.BitLen()
+	case *ecdsa.PublicKey:
+		return pk.Curve.Params().BitSize
+	case *dsa.PublicKey:
+		return pk.P.BitLen()
+	}
+	return 0
+}
+
+// SameKey reports whether both entries carry the same key blob,
+// regardless of options, comment or whitespace.
+func (e Entry) SameKey(o Entry) bool {
+	return e.Key != nil && o.Key != nil && bytes.Equal(e.Blob, o.Blob)
+}
+
+// Render renders the key as an authorized_keys line with options in front of it
+// and the original comment after it.
+func (e Entry) Render(options []string) string {
+	parts := make([]string, 0, 4)
+	if len(options) > 0 {
+		parts = append(parts, strings.Join(options, ","))
+	}
+	parts = append(parts, e.Type, base64.StdEncoding.EncodeToString(e.Blob))
+	if e.Comment != "" {
+		parts = append(parts, e.Comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+// HasOptions reports whether the entry carries exactly the given options, in order.
+func (e Entry) HasOptions(options []string) bool {
+	if len(e.Options) != len(options) {
+		return false
+	}
+	for i := range options {
+		if e.Options[i] != options[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLine parses one authorized_keys line. It returns an error if the line
+// is not blank or a comment and does not contain a valid key.
+func ParseLine(line string) (Entry, error) {
+	e := Entry{Raw: line}
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return e, nil
+	}
+	key, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(trimmed))
+	if err != nil {
+		return e, fmt.Errorf("parse authorized key: %w", err)
+	}
+	e.Key = key
+	e.Type = key.Type()
+	e.Blob = key.Marshal()
+	e.Comment = comment
+	e.Options = options
+	return e, nil
+}
+
+// ParsePublicKey parses the content of a .pub file (or an inline key string) and
+// returns its first key entry.
+func ParsePublicKey(data []byte) (Entry, error) {
+	keys, err := ParsePublicKeys(data)
+	if err != nil {
+		return Entry{}, err
+	}
+	return keys[0], nil
+}
+
+// ParsePublicKeys parses every key in data (one per line, blank lines and comments skipped).
+// Any invalid line is an error; so is input without a single key.
+func ParsePublicKeys(data []byte) ([]Entry, error) {
+	var out []Entry
+	for i, l := range strings.Split(string(data), "\n") {
+		e, err := ParseLine(l)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if e.IsKey() {
+			e.Line = i + 1
+			out = append(out, e)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no public key found")
+	}
+	return out, nil
+}
+
+// File is a parsed authorized_keys file.
+type File struct {
+	Entries []Entry
+}
+
+// Parse splits data into lines and parses each one. It never fails:
+// lines that are not valid keys are kept verbatim with a nil Key.
+func Parse(data []byte) *File {
+	f := &File{}
+	s := string(data)
+	if s == "" {
+		return f
+	}
+	s = strings.TrimSuffix(s, "\n")
+	for i, l := range strings.Split(s, "\n") {
+		e, err := ParseLine(l)
+		e.Err = err
+		e.Line = i + 1
+		f.Entries = append(f.Entries, e)
+	}
+	return f
+}
+
+// Keys returns only the entries that hold a public key.
+func (f *File) Keys() []Entry {
+	var out []Entry
+	for _, e := range f.Entries {
+		if e.IsKey() {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Find returns the entries whose key blob matches key.
+func (f *File) Find(key Entry) []Entry {
+	var out []Entry
+	for _, e := range f.Entries {
+		if e.SameKey(key) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Contains reports whether any entry carries the same key blob as key.
+func (f *File) Contains(key Entry) bool {
+	return len(f.Find(key)) > 0
+}
+
+// Append adds a raw line to the end of the file.
+func (f *File) Append(line string) {
+	e, _ := ParseLine(line)
+	e.Line = len(f.Entries) + 1
+	f.Entries = append(f.Entries, e)
+}
+
+// Remove drops every entry with the same key blob as key and returns how many were removed.
+func (f *File) Remove(key Entry) int {
+	kept := f.Entries[:0]
+	removed := 0
+	for _, e := range f.Entries {
+		if e.SameKey(key) {
+			removed++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	f.Entries = kept
+	return removed
+}
+
+// RemoveLine drops the entry parsed from line number line, leaving other lines with the
+// same key alone. It reports whether such an entry existed.
+func (f *File) RemoveLine(line int) bool {
+	for i, e := range f.Entries {
+		if e.Line == line {
+			f.Entries = append(f.Entries[:i], f.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Replace rewrites the first entry with the same key blob as key to line, in place,
+// and drops any later duplicates. It reports whether the file changed.
+// If the key is not present the file is left untouched and false is returned.
+func (f *File) Replace(key Entry, line string) bool {
+	kept := f.Entries[:0]
+	found, changed := false, false
+	for _, e := range f.Entries {
+		if !e.SameKey(key) {
+			kept = append(kept, e)
+			continue
+		}
+		if found {
+			changed = true
+			continue
+		}
+		found = true
+		if e.Raw != line {
+			ne, _ := ParseLine(line)
+			ne.Line = e.Line
+			e = ne
+			changed = true
+		}
+		kept = append(kept, e)
+	}
+	f.Entries = kept
+	return changed
+}
+
+// Bytes renders the file back to authorized_keys format, one entry per line.
+func (f *File) Bytes() []byte {
+	var b bytes.Buffer
+	for _, e := range f.Entries {
+		b.WriteString(e.Raw)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
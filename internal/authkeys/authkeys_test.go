@@ -0,0 +1,305 @@
+package authkeys
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	aliceKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMnJHrlG6MZoTr+wScNAKnDaPBdTDg49vboB4yU+Pik6"
+	bobKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAID0mRiWHT32pSuNOXtlxaRWhDkb4+4a3sbyPxIBWs0r1"
+)
+
+func mustKey(t *testing.T, line string) Entry {
+	t.Helper()
+	e, err := ParseLine(line)
+	if err != nil || !e.IsKey() {
+		t.Fatalf("ParseLine(%q) = %v", line, err)
+	}
+	return e
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		isKey   bool
+		options []string
+		comment string
+		wantErr bool
+	}{
+		{name: "plain", line: aliceKey + " alice@corp", isKey: true, comment: "alice@corp"},
+		{name: "no comment", line: aliceKey, isKey: true},
+		{name: "comment with spaces", line: aliceKey + " alice at corp", isKey: true, comment: "alice at corp"},
+		{name: "options", line: `no-pty,from="10.0.0.1" ` + aliceKey + " alice", isKey: true,
+			options: []string{"no-pty", `from="10.0.0.1"`}, comment: "alice"},
+		{name: "quoted commas", line: `command="echo a,b c",from="10.0.0.1,10.0.0.2" ` + aliceKey, isKey: true,
+			options: []string{`command="echo a,b c"`, `from="10.0.0.1,10.0.0.2"`}},
+		{name: "quoted escaped quote", line: `command="echo \"a,b\"" ` + aliceKey, isKey: true,
+			options: []string{`command="echo \"a,b\""`}},
+		{name: "leading whitespace", line: "  " + aliceKey + " alice", isKey: true, comment: "alice"},
+		{name: "CRLF", line: aliceKey + " alice\r", isKey: true, comment: "alice"},
+		{name: "blank", line: ""},
+		{name: "whitespace only", line: " \t"},
+		{name: "comment", line: "# " + aliceKey},
+		{name: "indented comment", line: "   # managed by hand"},
+		{name: "garbage", line: "garbage here", wantErr: true},
+		{name: "bad base64", line: "ssh-ed25519 AAAA!!!! alice", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if e.Raw != tt.line {
+				t.Errorf("Raw = %q, want %q", e.Raw, tt.line)
+			}
+			if e.IsKey() != tt.isKey {
+				t.Fatalf("IsKey = %v, want %v", e.IsKey(), tt.isKey)
+			}
+			if !reflect.DeepEqual(e.Options, tt.options) {
+				t.Errorf("Options = %q, want %q", e.Options, tt.options)
+			}
+			if e.Comment != tt.comment {
+				t.Errorf("Comment = %q, want %q", e.Comment, tt.comment)
+			}
+		})
+	}
+}
+
+func TestParseBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // "" = same as in
+	}{
+		{name: "empty", in: ""},
+		{name: "keys", in: aliceKey + " alice\n" + bobKey + " bob\n"},
+		{name: "comments and blank lines", in: "# team keys\n\n" + aliceKey + " alice\n   \n# end\n"},
+		{name: "trailing blank line", in: aliceKey + "\n\n"},
+		{name: "only a newline", in: "\n"},
+		{name: "CRLF", in: "# team\r\n" + aliceKey + " alice\r\n" + bobKey + " bob\r\n"},
+		{name: "invalid lines kept", in: "garbage here\n" + aliceKey + "\n"},
+		{name: "options with quoted commas", in: `command="echo a,b",no-pty ` + aliceKey + " alice\n"},
+		{name: "no trailing newline", in: aliceKey + " alice\n" + bobKey + " bob",
+			want: aliceKey + " alice\n" + bobKey + " bob\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.in
+			}
+			if got := string(Parse([]byte(tt.in)).Bytes()); got != want {
+				t.Errorf("Bytes() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseLineNumbersAndErrors(t *testing.T) {
+	f := Parse([]byte("# c\r\n\r\ngarbage\r\n" + aliceKey + " alice\r\n"))
+	if len(f.Entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(f.Entries))
+	}
+	for i, e := range f.Entries {
+		if e.Line != i+1 {
+			t.Errorf("entry %d: Line = %d", i, e.Line)
+		}
+	}
+	if f.Entries[0].Err != nil || f.Entries[1].Err != nil || f.Entries[2].Err == nil || f.Entries[3].Err != nil {
+		t.Errorf("Err = %v, %v, %v, %v; want only line 3 to fail",
+			f.Entries[0].Err, f.Entries[1].Err, f.Entries[2].Err, f.Entries[3].Err)
+	}
+	keys := f.Keys()
+	if len(keys) != 1 || keys[0].Comment != "alice" {
+		t.Errorf("Keys() = %+v, want only alice", keys)
+	}
+}
+
+func TestFileEdits(t *testing.T) {
+	alice, bob := mustKey(t, aliceKey), mustKey(t, bobKey)
+	in := "# keys\n" + aliceKey + " alice@old\n" + bobKey + " bob\n" + `no-pty ` + aliceKey + " alice@dup\n"
+
+	tests := []struct {
+		name    string
+		edit    func(f *File) any
+		want    string
+		wantRet any
+	}{
+		{name: "remove drops every line of the key", edit: func(f *File) any { return f.Remove(alice) },
+			want: "# keys\n" + bobKey + " bob\n", wantRet: 2},
+		{name: "remove absent key", edit: func(f *File) any { f.Remove(bob); return f.Remove(bob) },
+			want: "# keys\n" + aliceKey + " alice@old\n" + `no-pty ` + aliceKey + " alice@dup\n", wantRet: 0},
+		{name: "remove line keeps the other grant", edit: func(f *File) any { return f.RemoveLine(4) },
+			want: "# keys\n" + aliceKey + " alice@old\n" + bobKey + " bob\n", wantRet: true},
+		{name: "remove missing line", edit: func(f *File) any { return f.RemoveLine(9) },
+			want: in, wantRet: false},
+		{name: "replace rewrites first and drops duplicates", edit: func(f *File) any { return f.Replace(alice, aliceKey+" alice@new") },
+			want: "# keys\n" + aliceKey + " alice@new\n" + bobKey + " bob\n", wantRet: true},
+		{name: "replace absent key", edit: func(f *File) any {
+			f.Remove(bob)
+			return f.Replace(bob, bobKey+" bob2")
+		}, want: "# keys\n" + aliceKey + " alice@old\n" + `no-pty ` + aliceKey + " alice@dup\n", wantRet: false},
+		{name: "append", edit: func(f *File) any { f.Append(bobKey + " bob2"); return len(f.Find(bob)) },
+			want: in + bobKey + " bob2\n", wantRet: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Parse([]byte(in))
+			if got := tt.edit(f); got != tt.wantRet {
+				t.Errorf("returned %v, want %v", got, tt.wantRet)
+			}
+			if got := string(f.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceUnchanged(t *testing.T) {
+	alice := mustKey(t, aliceKey)
+	f := Parse([]byte(aliceKey + " alice\n"))
+	if f.Replace(alice, aliceKey+" alice") {
+		t.Error("Replace with the same line reported a change")
+	}
+}
+
+func TestParseDocumentManaged(t *testing.T) {
+	before := "# by hand\r\n" + bobKey + " bob@laptop\r\n"
+	after := "\n" + bobKey + " cloud-init\n# trailing"
+
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{name: "missing END", in: before + BeginMarker + "\n" + aliceKey + "\n", wantErr: "line 3: \"# BEGIN sync-ssh-id\" without \"# END sync-ssh-id\""},
+		{name: "END before BEGIN", in: EndMarker + "\n" + BeginMarker + "\n", wantErr: "line 1: unexpected"},
+		{name: "duplicate BEGIN", in: BeginMarker + "\n" + BeginMarker + "\n" + EndMarker + "\n", wantErr: "line 2: duplicate"},
+		{name: "second block", in: BeginMarker + "\n" + EndMarker + "\n" + EndMarker + "\n", wantErr: "line 3: unexpected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDocument([]byte(tt.in), true)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		in := before + BeginMarker + "\n" + aliceKey + " alice\n" + EndMarker + "\n" + after
+		d, err := ParseDocument([]byte(in), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(d.Bytes()); got != in {
+			t.Errorf("unchanged Bytes() = %q, want %q", got, in)
+		}
+		if string(d.Before) != before || string(d.After) != after {
+			t.Errorf("Before = %q, After = %q", d.Before, d.After)
+		}
+		if !d.Contains(mustKey(t, bobKey)) || d.Block.Contains(mustKey(t, bobKey)) {
+			t.Error("bob should be found outside the block only")
+		}
+		if e := d.Block.Entries[0]; e.Line != 4 {
+			t.Errorf("block entry Line = %d, want its line in the file, 4", e.Line)
+		}
+
+		d.Block.Remove(mustKey(t, aliceKey))
+		d.Block.Append(bobKey + " bob@managed")
+		want := before + BeginMarker + "\n" + bobKey + " bob@managed\n" + EndMarker + "\n" + after
+		if got := string(d.Bytes()); got != want {
+			t.Errorf("edited Bytes() = %q, want %q", got, want)
+		}
+
+		d.Block.Remove(mustKey(t, bobKey))
+		want = before + BeginMarker + "\n" + EndMarker + "\n" + after
+		if got := string(d.Bytes()); got != want {
+			t.Errorf("emptied Bytes() = %q, want the markers kept: %q", got, want)
+		}
+	})
+
+	t.Run("duplicate blobs in block", func(t *testing.T) {
+		in := BeginMarker + "\n" + aliceKey + " alice expires=2020-01-01\n" + aliceKey + " alice expires=2099-01-01\n" + EndMarker + "\n"
+		d, err := ParseDocument([]byte(in), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(d.Block.Find(mustKey(t, aliceKey))); n != 2 {
+			t.Fatalf("Find = %d entries, want 2", n)
+		}
+		if !d.Block.RemoveLine(d.Block.Entries[0].Line) {
+			t.Fatal("RemoveLine found nothing")
+		}
+		want := BeginMarker + "\n" + aliceKey + " alice expires=2099-01-01\n" + EndMarker + "\n"
+		if got := string(d.Bytes()); got != want {
+			t.Errorf("Bytes() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("no block yet", func(t *testing.T) {
+		in := aliceKey + " by hand" // no trailing newline
+		d, err := ParseDocument([]byte(in), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(d.Bytes()); got != in {
+			t.Errorf("untouched Bytes() = %q, want %q", got, in)
+		}
+		d.Block.Append(bobKey + " bob")
+		want := in + "\n" + BeginMarker + "\n" + bobKey + " bob\n" + EndMarker + "\n"
+		if got := string(d.Bytes()); got != want {
+			t.Errorf("Bytes() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("END without trailing newline", func(t *testing.T) {
+		in := BeginMarker + "\n" + aliceKey + "\n" + EndMarker
+		d, err := ParseDocument([]byte(in), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Block.Keys()) != 1 || len(d.After) != 0 {
+			t.Errorf("Block = %d keys, After = %q", len(d.Block.Keys()), d.After)
+		}
+	})
+}
+
+func TestParseDocumentUnmanaged(t *testing.T) {
+	in := "# hand\n" + BeginMarker + "\n" + aliceKey + "\n" + EndMarker + "\n"
+	d, err := ParseDocument([]byte(in), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Managed() || len(d.Block.Entries) != 4 || len(d.Outside().Entries) != 0 {
+		t.Errorf("unmanaged document: Managed = %v, %d block entries, %d outside", d.Managed(), len(d.Block.Entries), len(d.Outside().Entries))
+	}
+	if got := string(d.Bytes()); got != in {
+		t.Errorf("Bytes() = %q, want %q", got, in)
+	}
+}
+
+func TestHasOptions(t *testing.T) {
+	tests := []struct {
+		line    string
+		options []string
+		want    bool
+	}{
+		{line: aliceKey, options: nil, want: true},
+		{line: aliceKey, options: []string{}, want: true},
+		{line: aliceKey, options: []string{"restrict"}, want: false},
+		{line: "restrict " + aliceKey, options: nil, want: false},
+		{line: `restrict,command="a,b" ` + aliceKey, options: []string{"restrict", `command="a,b"`}, want: true},
+		{line: `command="a,b",restrict ` + aliceKey, options: []string{"restrict", `command="a,b"`}, want: false},
+		{line: `restrict,command="a" ` + aliceKey, options: []string{"restrict", `command="b"`}, want: false},
+	}
+	for _, tt := range tests {
+		if got := mustKey(t, tt.line).HasOptions(tt.options); got != tt.want {
+			t.Errorf("HasOptions(%q, %q) = %v, want %v", tt.line, tt.options, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package authkeys
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExpiresPrefix marks the expiry annotation sync-ssh-id adds to a key's comment next to the
+// expiry-time option, e.g. "bob@contractor expires=2025-06-30".
+const ExpiresPrefix = "expires="
+
+// expiryTimeLayouts are the forms of sshd's expiry-time option, YYYYMMDD[HHMM[SS]].
+var expiryTimeLayouts = []string{"20060102150405", "200601021504", "20060102"}
+
+// Expires returns when the key stops being accepted: the expires annotation if present,
+// otherwise the expiry-time option. ok is false when the entry has neither.
+func (e Entry) Expires() (t time.Time, ok bool, err error) {
+	if v := e.annotation(ExpiresPrefix); v != "" {
+		t, err := ParseExpires(v)
+		return t, true, err
+	}
+	for _, o := range e.Options {
+		name, v, found := strings.Cut(o, "=")
+		if !found || !strings.EqualFold(name, "expiry-time") {
+			continue
+		}
+		t, err := ParseExpiryTime(strings.Trim(v, `"`))
+		return t, true, err
+	}
+	return time.Time{}, false, nil
+}
+
+// WithExpires returns comment with its expires annotation set to t (removed when t is zero).
+func WithExpires(comment string, t time.Time) string {
+	if t.IsZero() {
+		return withAnnotation(comment, ExpiresPrefix, "")
+	}
+	return withAnnotation(comment, ExpiresPrefix, FormatExpires(t))
+}
+
+// ParseExpires parses an inventory expires value or annotation: a date (the key expires at
+// 00:00 UTC that day) or an RFC 3339 time.
+func ParseExpires(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q (want YYYY-MM-DD or RFC 3339)", v)
+}
+
+// FormatExpires renders t for the expires annotation: just the date at midnight UTC, RFC 3339 otherwise.
+func FormatExpires(t time.Time) string {
+	t = t.UTC()
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ParseExpiryTime parses an expiry-time option value. sshd reads values without a "Z" suffix
+// in the server's local time zone, which is unknown here; they are taken as UTC.
+func ParseExpiryTime(v string) (time.Time, error) {
+	s := strings.TrimSuffix(v, "Z")
+	for _, layout := range expiryTimeLayouts {
+		if len(s) != len(layout) {
+			continue
+		}
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry-time %q", v)
+}
+
+// FormatExpiryTime renders t as an expiry-time option value in UTC, e.g. "20250630Z".
+func FormatExpiryTime(t time.Time) string {
+	t = t.UTC()
+	switch {
+	case t.Equal(t.Truncate(24 * time.Hour)):
+		return t.Format("20060102") + "Z"
+	case t.Second() == 0:
+		return t.Format("200601021504") + "Z"
+	}
+	return t.Format("20060102150405") + "Z"
+}
@@ -0,0 +1,121 @@
+package authkeys
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpires(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "2025-06-30", want: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{in: " 2025-06-30 ", want: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{in: "2025-06-30T18:00:00Z", want: time.Date(2025, 6, 30, 18, 0, 0, 0, time.UTC)},
+		{in: "2025-06-30T18:00:00+02:00", want: time.Date(2025, 6, 30, 16, 0, 0, 0, time.UTC)},
+		{in: "20250630", wantErr: true},
+		{in: "2025-06-31", wantErr: true},
+		{in: "2025-06-30 18:00", wantErr: true},
+		{in: "tomorrow", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseExpires(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseExpires(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) || (err == nil && got.Location() != time.UTC) {
+			t.Errorf("ParseExpires(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseExpiryTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "20250630", want: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{in: "20250630Z", want: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{in: "202506301830", want: time.Date(2025, 6, 30, 18, 30, 0, 0, time.UTC)},
+		{in: "20250630183015Z", want: time.Date(2025, 6, 30, 18, 30, 15, 0, time.UTC)},
+		{in: "2025063018", wantErr: true},
+		{in: "2025-06-30", wantErr: true},
+		{in: "20251330", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseExpiryTime(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseExpiryTime(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseExpiryTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatExpiryTime(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC), "20250630Z"},
+		{time.Date(2025, 6, 30, 18, 30, 0, 0, time.UTC), "202506301830Z"},
+		{time.Date(2025, 6, 30, 18, 30, 15, 0, time.UTC), "20250630183015Z"},
+		{time.Date(2025, 7, 1, 2, 0, 0, 0, time.FixedZone("CEST", 2*3600)), "20250701Z"},
+	}
+	for _, tt := range tests {
+		got := FormatExpiryTime(tt.in)
+		if got != tt.want {
+			t.Errorf("FormatExpiryTime(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+		back, err := ParseExpiryTime(got)
+		if err != nil || !back.Equal(tt.in) {
+			t.Errorf("ParseExpiryTime(%q) = %v, %v; want %v", got, back, err, tt.in)
+		}
+	}
+}
+
+func TestFormatExpires(t *testing.T) {
+	for _, in := range []string{"2025-06-30", "2025-06-30T18:00:00Z"} {
+		tm, err := ParseExpires(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatExpires(tm); got != in {
+			t.Errorf("FormatExpires(ParseExpires(%q)) = %q", in, got)
+		}
+	}
+}
+
+func TestEntryExpires(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMnJHrlG6MZoTr+wScNAKnDaPBdTDg49vboB4yU+Pik6"
+	tests := []struct {
+		line    string
+		want    time.Time
+		ok      bool
+		wantErr bool
+	}{
+		{line: key + " bob", ok: false},
+		{line: key + " bob expires=2025-06-30", want: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC), ok: true},
+		{line: `expiry-time="20250630Z" ` + key + " bob", want: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC), ok: true},
+		{line: `expiry-time="20300101Z" ` + key + " bob expires=2025-06-30", want: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC), ok: true},
+		{line: key + " bob expires=someday", ok: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		e, err := ParseLine(tt.line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q): %v", tt.line, err)
+		}
+		got, ok, err := e.Expires()
+		if ok != tt.ok || (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("Expires(%q) = %v, %v, %v; want %v, %v, err %v", tt.line, got, ok, err, tt.want, tt.ok, tt.wantErr)
+		}
+	}
+}
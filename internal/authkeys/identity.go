@@ -0,0 +1,65 @@
+package authkeys
+
+import "strings"
+
+// KeyIDPrefix marks the identity annotation sync-ssh-id adds to a key's comment,
+// e.g. "alice@corp key_id=alice". sshd ignores the comment, so the annotation is harmless.
+const KeyIDPrefix = "key_id="
+
+// annotationPrefixes are the comment fields sync-ssh-id manages itself.
+var annotationPrefixes = []string{KeyIDPrefix, ExpiresPrefix}
+
+// KeyID returns the value of the entry's key_id annotation, or "".
+func (e Entry) KeyID() string {
+	return e.annotation(KeyIDPrefix)
+}
+
+// BaseComment returns the comment without the key_id and expires annotations.
+func (e Entry) BaseComment() string {
+	var kept []string
+	for _, f := range strings.Fields(e.Comment) {
+		if !isAnnotation(f) {
+			kept = append(kept, f)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// WithKeyID returns comment with its key_id annotation set to id (removed when id is empty).
+func WithKeyID(comment, id string) string {
+	return withAnnotation(comment, KeyIDPrefix, id)
+}
+
+// annotation returns the value of the comment field starting with prefix, or "".
+func (e Entry) annotation(prefix string) string {
+	for _, f := range strings.Fields(e.Comment) {
+		if v, ok := strings.CutPrefix(f, prefix); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// withAnnotation returns comment with the prefix field set to value, appended after the other
+// fields (removed when value is empty).
+func withAnnotation(comment, prefix, value string) string {
+	var kept []string
+	for _, f := range strings.Fields(comment) {
+		if !strings.HasPrefix(f, prefix) {
+			kept = append(kept, f)
+		}
+	}
+	if value != "" {
+		kept = append(kept, prefix+value)
+	}
+	return strings.Join(kept, " ")
+}
+
+func isAnnotation(field string) bool {
+	for _, p := range annotationPrefixes {
+		if strings.HasPrefix(field, p) {
+			return true
+		}
+	}
+	return false
+}
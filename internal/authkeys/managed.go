@@ -0,0 +1,118 @@
+package authkeys
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Markers delimiting the region of authorized_keys owned by sync-ssh-id.
+const (
+	BeginMarker = "# BEGIN sync-ssh-id"
+	EndMarker   = "# END sync-ssh-id"
+)
+
+// Document is an authorized_keys file split around the managed block.
+// In managed mode only Block may be edited; Before and After are written back byte-for-byte.
+// In unmanaged mode Block holds the whole file and Before/After are empty.
+type Document struct {
+	Before []byte // raw bytes up to (not including) the BEGIN marker line
+	Block  *File  // entries the tool may edit
+	After  []byte // raw bytes following the END marker line
+
+	managed  bool
+	hasBlock bool
+	outside  *File
+}
+
+// ParseDocument parses an authorized_keys file. With managed set, the lines between
+// BeginMarker and EndMarker become the editable block (empty if there are no markers yet).
+func ParseDocument(data []byte, managed bool) (*Document, error) {
+	if !managed {
+		return &Document{Block: Parse(data), outside: &File{}}, nil
+	}
+
+	d := &Document{managed: true}
+	begin, end := -1, -1 // byte offsets of the marker lines
+	beginLine := 0
+	off := 0
+	for i, l := range strings.SplitAfter(string(data), "\n") {
+		switch strings.TrimSpace(l) {
+		case BeginMarker:
+			if begin >= 0 {
+				return nil, fmt.Errorf("authorized_keys line %d: duplicate %q", i+1, BeginMarker)
+			}
+			begin, beginLine = off, i+1
+		case EndMarker:
+			if begin < 0 || end >= 0 {
+				return nil, fmt.Errorf("authorized_keys line %d: unexpected %q", i+1, EndMarker)
+			}
+			end = off
+			d.Before = data[:begin]
+			d.Block = Parse(data[begin+lineLen(data[begin:]) : end])
+			d.After = data[end+lineLen(data[end:]):]
+		}
+		off += len(l)
+	}
+	if begin >= 0 && end < 0 {
+		return nil, fmt.Errorf("authorized_keys line %d: %q without %q", beginLine, BeginMarker, EndMarker)
+	}
+
+	if begin < 0 {
+		d.Before = data
+		d.Block = &File{}
+	} else {
+		d.hasBlock = true
+		for i := range d.Block.Entries {
+			d.Block.Entries[i].Line += beginLine
+		}
+	}
+
+	d.outside = Parse(append(append([]byte{}, d.Before...), d.After...))
+	return d, nil
+}
+
+// lineLen returns the length of the first line of b including its newline.
+func lineLen(b []byte) int {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		return i + 1
+	}
+	return len(b)
+}
+
+// Managed reports whether the document confines edits to the managed block.
+func (d *Document) Managed() bool {
+	return d.managed
+}
+
+// Outside returns the read-only entries outside the managed block (empty in unmanaged mode).
+func (d *Document) Outside() *File {
+	return d.outside
+}
+
+// Contains reports whether key is present anywhere in the file, inside or outside the block.
+func (d *Document) Contains(key Entry) bool {
+	return d.Block.Contains(key) || d.outside.Contains(key)
+}
+
+// Bytes renders the document. Content outside the managed block is returned unchanged;
+// an empty block is only written if the file already had one.
+func (d *Document) Bytes() []byte {
+	if !d.managed {
+		return d.Block.Bytes()
+	}
+	if !d.hasBlock && len(d.Block.Entries) == 0 {
+		return d.Before
+	}
+
+	var b bytes.Buffer
+	b.Write(d.Before)
+	if len(d.Before) > 0 && d.Before[len(d.Before)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	b.WriteString(BeginMarker + "\n")
+	b.Write(d.Block.Bytes())
+	b.WriteString(EndMarker + "\n")
+	b.Write(d.After)
+	return b.Bytes()
+}
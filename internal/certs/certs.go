@@ -0,0 +1,248 @@
+// Package certs signs OpenSSH user certificates with a user CA key.
+package certs
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Request describes the certificate to issue for one public key.
+type Request struct {
+	KeyID           string
+	Principals      []string
+	Serial          uint64
+	ValidAfter      time.Time // zero = valid since always
+	ValidBefore     time.Time // zero = valid forever
+	CriticalOptions map[string]string
+	Extensions      map[string]string
+}
+
+// permits are the extensions ssh-keygen grants user certificates by default.
+var permits = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// NewRequest returns a request for keyID with ssh-keygen's default extensions and no critical options.
+func NewRequest(keyID string) *Request {
+	r := &Request{KeyID: keyID, CriticalOptions: map[string]string{}, Extensions: map[string]string{}}
+	for _, p := range permits {
+		r.Extensions[p] = ""
+	}
+	return r
+}
+
+// ApplyOption applies one option in ssh-keygen -O syntax:
+//
+//	clear                      drop every extension
+//	force-command=CMD          critical option
+//	source-address=CIDR[,...]  critical option
+//	verify-required            critical option (FIDO user verification)
+//	no-touch-required          extension
+//	no-pty, permit-pty, ...    remove or add one of the default permit-* extensions
+//	critical:NAME[=VALUE]      any other critical option
+//	extension:NAME[=VALUE]     any other extension
+func (r *Request) ApplyOption(opt string) error {
+	name, value, hasValue := strings.Cut(strings.TrimSpace(opt), "=")
+	switch lower := strings.ToLower(name); {
+	case lower == "clear":
+		r.Extensions = map[string]string{}
+	case lower == "force-command":
+		if !hasValue || value == "" {
+			return fmt.Errorf("option %q: missing command", opt)
+		}
+		r.CriticalOptions["force-command"] = value
+	case lower == "source-address":
+		if err := checkSourceAddress(value); err != nil {
+			return fmt.Errorf("option %q: %w", opt, err)
+		}
+		r.CriticalOptions["source-address"] = value
+	case lower == "verify-required":
+		r.CriticalOptions["verify-required"] = ""
+	case lower == "no-touch-required":
+		r.Extensions["no-touch-required"] = ""
+	case strings.HasPrefix(lower, "critical:"):
+		r.CriticalOptions[name[len("critical:"):]] = value
+	case strings.HasPrefix(lower, "extension:"):
+		r.Extensions[name[len("extension:"):]] = value
+	case strings.HasPrefix(lower, "no-"), strings.HasPrefix(lower, "permit-"):
+		p, ok := permitName(strings.TrimPrefix(strings.TrimPrefix(lower, "no-"), "permit-"))
+		if !ok {
+			return fmt.Errorf("unknown option %q", opt)
+		}
+		if strings.HasPrefix(lower, "no-") {
+			delete(r.Extensions, p)
+		} else {
+			r.Extensions[p] = ""
+		}
+	default:
+		return fmt.Errorf("unknown option %q", opt)
+	}
+	return nil
+}
+
+// permitName maps e.g. "x11-forwarding" to "permit-X11-forwarding".
+func permitName(suffix string) (string, bool) {
+	for _, p := range permits {
+		if strings.EqualFold(p, "permit-"+suffix) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func checkSourceAddress(list string) error {
+	if strings.TrimSpace(list) == "" {
+		return fmt.Errorf("missing address list")
+	}
+	for _, a := range strings.Split(list, ",") {
+		if net.ParseIP(a) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			return fmt.Errorf("%q is not an address or CIDR", a)
+		}
+	}
+	return nil
+}
+
+// Sign issues a user certificate for pub signed by ca. RSA CAs use an rsa-sha2 signature, never SHA-1.
+func Sign(ca ssh.Signer, pub ssh.PublicKey, r *Request) (*ssh.Certificate, error) {
+	if strings.TrimSpace(r.KeyID) == "" {
+		return nil, fmt.Errorf("a key ID is required")
+	}
+	if len(r.Principals) == 0 {
+		return nil, fmt.Errorf("at least one principal is required")
+	}
+	if _, ok := pub.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("cannot sign a certificate, only a plain public key")
+	}
+	if !r.ValidBefore.IsZero() && !r.ValidBefore.After(r.ValidAfter) {
+		return nil, fmt.Errorf("validity window ends before it starts")
+	}
+
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          r.Serial,
+		CertType:        ssh.UserCert,
+		KeyId:           r.KeyID,
+		ValidPrincipals: r.Principals,
+		ValidBefore:     ssh.CertTimeInfinity,
+		Permissions: ssh.Permissions{
+			CriticalOptions: r.CriticalOptions,
+			Extensions:      r.Extensions,
+		},
+	}
+	if !r.ValidAfter.IsZero() {
+		cert.ValidAfter = uint64(r.ValidAfter.Unix())
+	}
+	if !r.ValidBefore.IsZero() {
+		cert.ValidBefore = uint64(r.ValidBefore.Unix())
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+	return cert, nil
+}
+
+// ParseValidity parses a validity window in ssh-keygen -V syntax, relative to now:
+// "FROM:TO" or "+52w" (until then, starting like ssh-keygen a minute before now rounded down
+// to the minute, for hosts with skewed clocks). Each time is "always" (start) or "forever"
+// (end), a relative offset like "-5m" or "+1w2d" (units s, m, h, d, w; none means seconds),
+// or YYYYMMDD[HHMM[SS]] in local time (UTC with a "Z" suffix). A zero time means unbounded.
+func ParseValidity(spec string, now time.Time) (after, before time.Time, err error) {
+	spec = strings.TrimSpace(spec)
+	from, to, ok := strings.Cut(spec, ":")
+	if !ok {
+		if strings.HasPrefix(spec, "+") {
+			d, err := parseOffset(spec)
+			return now.Add(-59 * time.Second).Truncate(time.Minute), now.Add(d), err
+		}
+		return time.Time{}, time.Time{}, fmt.Errorf("validity %q: want FROM:TO or +OFFSET", spec)
+	}
+	if after, err = parseValidityTime(from, now, "always"); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if before, err = parseValidityTime(to, now, "forever"); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return after, before, nil
+}
+
+func parseValidityTime(v string, now time.Time, unbounded string) (time.Time, error) {
+	switch {
+	case strings.EqualFold(v, unbounded):
+		return time.Time{}, nil
+	case strings.HasPrefix(v, "+"), strings.HasPrefix(v, "-"):
+		d, err := parseOffset(v)
+		return now.Add(d), err
+	}
+	loc := time.Local
+	s := v
+	if strings.HasSuffix(s, "Z") {
+		s, loc = strings.TrimSuffix(s, "Z"), time.UTC
+	}
+	for _, layout := range []string{"20060102150405", "200601021504", "20060102"} {
+		if len(s) == len(layout) {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid validity time %q", v)
+}
+
+// parseOffset parses a signed offset such as "+52w", "-5m" or "+1w2d".
+func parseOffset(v string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(v, "-") {
+		sign = -1
+	}
+	rest := v[1:]
+	if rest == "" {
+		return 0, fmt.Errorf("invalid offset %q", v)
+	}
+	var total time.Duration
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid offset %q", v)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset %q", v)
+		}
+		unit := time.Second
+		if i < len(rest) {
+			switch rest[i] {
+			case 's', 'S':
+			case 'm', 'M':
+				unit = time.Minute
+			case 'h', 'H':
+				unit = time.Hour
+			case 'd', 'D':
+				unit = 24 * time.Hour
+			case 'w', 'W':
+				unit = 7 * 24 * time.Hour
+			default:
+				return 0, fmt.Errorf("invalid offset %q", v)
+			}
+			i++
+		}
+		total += time.Duration(n) * unit
+		rest = rest[i:]
+	}
+	return sign * total, nil
+}
@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The expectations follow ssh-keygen -V and -O as documented in ssh-keygen(1).
+
+func TestParseValidity(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	tests := []struct {
+		spec       string
+		wantAfter  time.Time
+		wantBefore time.Time
+		wantErr    bool
+	}{
+		// a single +OFFSET: valid from a minute before now, rounded down, until then
+		{spec: "+52w", wantAfter: now.Add(-time.Minute), wantBefore: now.Add(52 * week)},
+		{spec: "+52w1d", wantAfter: now.Add(-time.Minute), wantBefore: now.Add(52*week + 24*time.Hour)},
+		{spec: "+3600", wantAfter: now.Add(-time.Minute), wantBefore: now.Add(time.Hour)},
+		{spec: "+1h30M", wantAfter: now.Add(-time.Minute), wantBefore: now.Add(90 * time.Minute)},
+		{spec: " +1d ", wantAfter: now.Add(-time.Minute), wantBefore: now.Add(24 * time.Hour)},
+		// FROM:TO
+		{spec: "-5m:+52w", wantAfter: now.Add(-5 * time.Minute), wantBefore: now.Add(52 * week)},
+		{spec: "-4w:+4w", wantAfter: now.Add(-4 * week), wantBefore: now.Add(4 * week)},
+		{spec: "always:forever"},
+		{spec: "ALWAYS:FOREVER"},
+		{spec: "always:+1w", wantBefore: now.Add(week)},
+		{spec: "-1d:forever", wantAfter: now.Add(-24 * time.Hour)},
+		{spec: "20250101Z:20260101Z",
+			wantAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), wantBefore: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "20100101123000Z:201101011230Z",
+			wantAfter: time.Date(2010, 1, 1, 12, 30, 0, 0, time.UTC), wantBefore: time.Date(2011, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{spec: "-1d:20260101",
+			wantAfter: now.Add(-24 * time.Hour), wantBefore: time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)},
+		{spec: "20250101:20250102",
+			wantAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local), wantBefore: time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local)},
+		// invalid
+		{spec: "", wantErr: true},
+		{spec: "52w", wantErr: true},
+		{spec: "-1d", wantErr: true},
+		{spec: "+", wantErr: true},
+		{spec: "+w", wantErr: true},
+		{spec: "+1y", wantErr: true},
+		{spec: "forever:always", wantErr: true},
+		{spec: "always:", wantErr: true},
+		{spec: ":forever", wantErr: true},
+		{spec: "2025-01-01:forever", wantErr: true},
+		{spec: "20251301Z:forever", wantErr: true},
+		{spec: "2025010112Z:forever", wantErr: true},
+	}
+	for _, tt := range tests {
+		after, before, err := ParseValidity(tt.spec, now)
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseValidity(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !after.Equal(tt.wantAfter) || !before.Equal(tt.wantBefore) {
+			t.Errorf("ParseValidity(%q) = %v, %v; want %v, %v", tt.spec, after, before, tt.wantAfter, tt.wantBefore)
+		}
+	}
+}
+
+func TestParseValidityBackdatesStart(t *testing.T) {
+	// ssh-keygen starts a single +OFFSET at ((now - 59) / 60) * 60
+	for _, tt := range []struct{ now, want string }{
+		{"2025-03-01T12:00:00Z", "2025-03-01T11:59:00Z"},
+		{"2025-03-01T12:00:26Z", "2025-03-01T11:59:00Z"},
+		{"2025-03-01T12:00:59Z", "2025-03-01T12:00:00Z"},
+	} {
+		now, _ := time.Parse(time.RFC3339, tt.now)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		if after, _, err := ParseValidity("+1h", now); err != nil || !after.Equal(want) {
+			t.Errorf("ParseValidity(+1h) at %s starts %v, %v; want %v", tt.now, after, err, want)
+		}
+	}
+}
+
+func TestApplyOption(t *testing.T) {
+	defaults := map[string]string{
+		"permit-X11-forwarding":   "",
+		"permit-agent-forwarding": "",
+		"permit-port-forwarding":  "",
+		"permit-pty":              "",
+		"permit-user-rc":          "",
+	}
+	without := func(names ...string) map[string]string {
+		m := map[string]string{}
+		for k, v := range defaults {
+			m[k] = v
+		}
+		for _, n := range names {
+			delete(m, n)
+		}
+		return m
+	}
+	tests := []struct {
+		name     string
+		opts     []string
+		critical map[string]string
+		exts     map[string]string
+		wantErr  string
+	}{
+		{name: "defaults", exts: defaults},
+		{name: "clear", opts: []string{"clear"}, exts: map[string]string{}},
+		{name: "clear then permit-pty", opts: []string{"clear", "permit-pty"}, exts: map[string]string{"permit-pty": ""}},
+		{name: "no-* removes permits", opts: []string{"no-x11-forwarding", "no-agent-forwarding", "no-port-forwarding", "no-pty", "no-user-rc"},
+			exts: map[string]string{}},
+		{name: "case-insensitive", opts: []string{"No-X11-Forwarding", "clear", "PERMIT-X11-FORWARDING"},
+			exts: map[string]string{"permit-X11-forwarding": ""}},
+		{name: "force-command", opts: []string{"force-command=/usr/bin/backup --run=1"},
+			critical: map[string]string{"force-command": "/usr/bin/backup --run=1"}, exts: defaults},
+		{name: "source-address", opts: []string{"source-address=10.0.0.0/8,192.0.2.1,2001:db8::/32"},
+			critical: map[string]string{"source-address": "10.0.0.0/8,192.0.2.1,2001:db8::/32"}, exts: defaults},
+		{name: "verify-required", opts: []string{"verify-required"},
+			critical: map[string]string{"verify-required": ""}, exts: defaults},
+		{name: "no-touch-required", opts: []string{"no-touch-required", "no-pty"},
+			exts: func() map[string]string { m := without("permit-pty"); m["no-touch-required"] = ""; return m }()},
+		{name: "critical and extension", opts: []string{"critical:custom@corp=v=1", "extension:login@corp", "extension:permit-pty="},
+			critical: map[string]string{"custom@corp": "v=1"},
+			exts:     func() map[string]string { m := without(); m["login@corp"] = ""; return m }()},
+		{name: "force-command without command", opts: []string{"force-command="}, wantErr: "missing command"},
+		{name: "force-command without =", opts: []string{"force-command"}, wantErr: "missing command"},
+		{name: "bad source-address", opts: []string{"source-address=10.0.0.0/33"}, wantErr: "not an address or CIDR"},
+		{name: "empty source-address", opts: []string{"source-address="}, wantErr: "missing address list"},
+		{name: "unknown no-", opts: []string{"no-such-thing"}, wantErr: "unknown option"},
+		{name: "unknown", opts: []string{"restrict"}, wantErr: "unknown option"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRequest("id")
+			var err error
+			for _, o := range tt.opts {
+				if err = r.ApplyOption(o); err != nil {
+					break
+				}
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			critical := tt.critical
+			if critical == nil {
+				critical = map[string]string{}
+			}
+			if !reflect.DeepEqual(r.CriticalOptions, critical) {
+				t.Errorf("CriticalOptions = %v, want %v", r.CriticalOptions, critical)
+			}
+			if !reflect.DeepEqual(r.Extensions, tt.exts) {
+				t.Errorf("Extensions = %v, want %v", r.Extensions, tt.exts)
+			}
+		})
+	}
+}
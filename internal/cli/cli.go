@@ -6,11 +6,17 @@ import (
 )
 
 type Options struct {
-	Interactive bool
-	EnvDir      string
-	DryRun      bool
-	ConfigPath  string
-	Args        []string // leftover args
+	Interactive       bool
+	EnvDir            string
+	DryRun            bool
+	ConfigPath        string
+	Concurrency       int      // hosts processed in parallel; 0 = use inventory options
+	Action            string   // overrides every server's action when set
+	Limit             string   // comma-separated server names/hosts to process (default all)
+	Backup            string   // backup to restore for rollback (name or timestamp; default newest)
+	Verbose           bool     // add a per-host timing line
+	GenerateIfMissing bool     // create the default key pair when it does not exist
+	Args              []string // leftover args
 
 	Host       string // in interactive mode can be user@host
 	Pass       string
@@ -25,12 +31,18 @@ func ParseFlags() *Options {
 	flag.BoolVar(&opts.Interactive, "i", false, "interactive single-host mode")
 	flag.StringVar(&opts.EnvDir, "env-dir", "configs", "directory to search env files")
 	flag.BoolVar(&opts.DryRun, "dry-run", false, "dry run - no changes")
+	flag.IntVar(&opts.Concurrency, "concurrency", 0, "number of hosts to process in parallel (overrides options.concurrency)")
+	flag.StringVar(&opts.Action, "action", "", "override the action of every server (e.g. rotate, check, doctor, prune-expired, trust-ca, list, list-backups, rollback; comma-separate several to run them over one connection); with -i: inject (default) or list")
+	flag.StringVar(&opts.Limit, "limit", "", "only process these servers (comma-separated names, hosts or IPs)")
+	flag.BoolVar(&opts.Verbose, "verbose", false, "print a per-host timing breakdown (dial, read, write, verify, ...)")
+	flag.BoolVar(&opts.GenerateIfMissing, "generate-if-missing", false, "create an ed25519 key pair when the default public key (~/.ssh/id_ed25519.pub, id_ecdsa.pub or id_rsa.pub) does not exist")
+	flag.StringVar(&opts.Backup, "backup", "", "backup to restore with rollback (file name or timestamp; default newest)")
 
 	flag.StringVar(&opts.Host, "host", "", "target hostname or IP (can be user@host)")
 	flag.StringVar(&opts.Pass, "pass", "", "remote password")
 	// keep flag names compatible with earlier examples (local_path / remote_path)
 	flag.StringVar(&opts.PubKey, "local_path", "", "local public key path")
-	flag.StringVar(&opts.RemotePath, "remote_path", "", "remote authorized_keys path")
+	flag.StringVar(&opts.RemotePath, "remote_path", "", "remote authorized_keys path, or auto to read it from the sshd configuration")
 	flag.StringVar(&opts.Port, "port", "", "ssh port for interactive mode (optional)")
 
 	flag.Parse()
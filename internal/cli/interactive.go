@@ -28,10 +28,21 @@ func RunInteractive(opts *Options) error {
 	parts := strings.SplitN(target, "@", 2)
 	user := parts[0]
 	host := parts[1]
+	action := strings.ToLower(strings.TrimSpace(opts.Action))
+	switch action {
+	case "", "inject", "add", "list", "audit":
+	default:
+		return fmt.Errorf("action %q is not supported with -i (use inject or list, or an inventory for other actions)", action)
+	}
 
 	// auto-detect key paths
 	if strings.TrimSpace(opts.PubKey) == "" {
 		opts.PubKey = util.DetectDefaultPubKey()
+		if opts.GenerateIfMissing && !opts.DryRun && action != "list" && action != "audit" {
+			if err := generateIfMissing(opts.PubKey); err != nil {
+				return err
+			}
+		}
 	}
 	if strings.TrimSpace(opts.RemotePath) == "" {
 		opts.RemotePath = "~/.ssh/authorized_keys"
@@ -48,10 +59,21 @@ func RunInteractive(opts *Options) error {
 		opts.Pass = strings.TrimSpace(string(pw))
 	}
 
+	// read-only audit of the remote authorized_keys
+	if action == "list" || action == "audit" {
+		h := config.Server{Name: host, Host: host, User: user, Action: action, Pass: opts.Pass, Port: opts.Port}
+		remotePath := strings.TrimSpace(opts.RemotePath)
+		keys, err := ops.NewKeyManager().List(h, remotePath)
+		out := &output.Buffer{}
+		reportKeyInfo(out, h, remotePath, keys, err)
+		out.Flush()
+		return err
+	}
+
 	// expand and clean local pubkey path
 	pubkeyPath := filepath.Clean(os.ExpandEnv(opts.PubKey))
 	if _, err := os.Stat(pubkeyPath); err != nil {
-		return fmt.Errorf("public key not found at %s", pubkeyPath)
+		return fmt.Errorf("public key not found at %s (create one with sync-ssh-id keygen or --generate-if-missing)", pubkeyPath)
 	}
 
 	// load public key file
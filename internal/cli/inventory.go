@@ -1,24 +1,46 @@
 package cli
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
 	"github.com/thineshsubramani/sync-ssh-id/internal/config"
 	"github.com/thineshsubramani/sync-ssh-id/internal/env"
 	"github.com/thineshsubramani/sync-ssh-id/internal/ops"
 	"github.com/thineshsubramani/sync-ssh-id/internal/output"
+	"github.com/thineshsubramani/sync-ssh-id/internal/util"
 )
 
+// ErrDrift is returned (wrapped) by RunInventory when every host was reachable but at least one
+// does not match the inventory, so callers can tell drift apart from failures.
+var ErrDrift = errors.New("drift detected")
+
 // defaultRemotePath returns the default remote authorized_keys path
 func defaultRemotePath() string {
 	return "~/.ssh/authorized_keys"
 }
 
+// hostRemotePath returns the authorized_keys path shown for h ("auto" until resolved remotely).
+func hostRemotePath(h config.Server) string {
+	if p := strings.TrimSpace(h.RemotePath); p != "" {
+		return p
+	}
+	return defaultRemotePath()
+}
+
 // RunInventory processes inventory YAML, rendering each server separately with its env map.
-// It prints only one final status line per server (OK or ERROR).
+// Hosts are processed by a bounded worker pool (see --concurrency / options.concurrency);
+// it prints only one final status line per server (OK or ERROR), in inventory order.
+// It returns an error if any host failed, was skipped, or (for check) drifted.
 func RunInventory(opts *Options) error {
 	raw, err := config.LoadRaw(opts.ConfigPath)
 	if err != nil {
@@ -30,7 +52,77 @@ func RunInventory(opts *Options) error {
 		return err
 	}
 
+	hosts, err := resolveHosts(opts, inv)
+	if err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("no servers selected")
+	}
+
+	maxAge, err := inv.Options.BackupMaxAgeDuration()
+	if err != nil {
+		return err
+	}
+	lockTimeout, err := inv.Options.LockTimeoutDuration()
+	if err != nil {
+		return err
+	}
+	urlCacheMaxAge, err := inv.Options.URLCacheMaxAgeDuration()
+	if err != nil {
+		return err
+	}
+
+	if opts.GenerateIfMissing && !opts.DryRun && usesDefaultKey(hosts) {
+		if err := generateIfMissing(util.DetectDefaultPubKey()); err != nil {
+			return err
+		}
+	}
+
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = inv.Options.Concurrency
+	}
+
+	// First Ctrl-C stops dispatching new hosts and lets in-flight ones finish;
+	// once ctx is done we restore default signal handling so a second Ctrl-C exits hard.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	mgr := ops.NewKeyManager()
+	mgr.BackupKeep = inv.Options.BackupKeep
+	mgr.BackupMaxAge = maxAge
+	mgr.LockTimeout = lockTimeout
+	mgr.URLCacheMaxAge = urlCacheMaxAge
+
+	failed, drifted, skipped := runPool(ctx, hosts, workers, func(ctx context.Context, h config.Server, out *output.Buffer) {
+		runHost(mgr, opts, h, out)
+	})
+
+	switch {
+	case skipped > 0:
+		return fmt.Errorf("interrupted: %d of %d hosts not processed", skipped, len(hosts))
+	case failed > 0:
+		return fmt.Errorf("%d of %d hosts failed", failed, len(hosts))
+	case drifted > 0:
+		return fmt.Errorf("%w on %d of %d hosts", ErrDrift, drifted, len(hosts))
+	}
+	return nil
+}
+
+// resolveHosts renders every server block with its own env map and applies
+// password / public key precedence, returning fully-resolved servers in inventory order.
+func resolveHosts(opts *Options, inv *config.Inventory) ([]config.Server, error) {
+	var out []config.Server
 	for _, srv := range inv.Servers {
+		if !selected(opts.Limit, srv) {
+			continue
+		}
+
 		// get per-host env map (does NOT mutate process env)
 		envMap, _, _ := env.SmartEnvMap(opts.EnvDir, srv.Host, srv.IP)
 
@@ -38,18 +130,18 @@ func RunInventory(opts *Options) error {
 		smallInv := config.Inventory{Servers: []config.Server{srv}}
 		smallRaw, err := yaml.Marshal(&smallInv)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// render only the small YAML with envMap (no cross-talk)
 		rendered, err := config.RenderForHost(smallRaw, envMap)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		hostInv, err := config.ParseInventory(rendered)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// should be exactly one server here
@@ -69,11 +161,26 @@ func RunInventory(opts *Options) error {
 				}
 			}
 
+			// SUDO PASSWORD PRECEDENCE (target_user with become: sudo):
+			// 1) explicit h.BecomePass (yaml: become_pass)
+			// 2) per-host env: envMap["SUDO_PASS"]
+			// 3) global env: os.Getenv("SUDO_PASS")
+			// 4) the login password resolved above
+			if strings.TrimSpace(h.BecomePass) == "" {
+				if v, ok := envMap["SUDO_PASS"]; ok && strings.TrimSpace(v) != "" {
+					h.BecomePass = strings.TrimSpace(v)
+				} else if global := os.Getenv("SUDO_PASS"); strings.TrimSpace(global) != "" {
+					h.BecomePass = strings.TrimSpace(global)
+				} else {
+					h.BecomePass = h.Pass
+				}
+			}
+
 			// PUBLIC KEY PRECEDENCE:
-			// 1) explicit in YAML h.PublicKey
+			// 1) explicit in YAML h.PublicKey / h.PublicKeys
 			// 2) envMap["PUB_KEY_PATH"]
 			// 3) global PUB_KEY_PATH env
-			if strings.TrimSpace(h.PublicKey) == "" {
+			if strings.TrimSpace(h.PublicKey) == "" && len(h.PublicKeys) == 0 {
 				if v, ok := envMap["PUB_KEY_PATH"]; ok && strings.TrimSpace(v) != "" {
 					h.PublicKey = strings.TrimSpace(v)
 				} else if global := os.Getenv("PUB_KEY_PATH"); strings.TrimSpace(global) != "" {
@@ -81,39 +188,298 @@ func RunInventory(opts *Options) error {
 				}
 			}
 
-			remotePath := defaultRemotePath()
+			// inventory-wide defaults for settings the server block leaves unset
+			h.ApplyDefaults(inv.Options)
 
-			// If dry-run: don't perform actions, just print OK once
-			if opts.DryRun {
-				output.OK(h, remotePath)
-				continue
+			// command-line overrides
+			if strings.TrimSpace(opts.Action) != "" {
+				h.Action = strings.TrimSpace(opts.Action)
+			}
+			if strings.TrimSpace(opts.Backup) != "" {
+				h.Backup = strings.TrimSpace(opts.Backup)
+			}
+			if strings.TrimSpace(opts.RemotePath) != "" {
+				h.RemotePath = strings.TrimSpace(opts.RemotePath)
 			}
 
-			mgr := ops.NewKeyManager()
-			action := strings.ToLower(h.Action)
-			switch action {
-			case "inject", "add":
-				if err := mgr.Inject(h); err != nil {
-					output.Error(h, remotePath, err)
-				} else {
-					output.OK(h, remotePath)
-				}
-			case "delete", "remove":
-				if err := mgr.Delete(h); err != nil {
-					output.Error(h, remotePath, err)
-				} else {
-					output.OK(h, remotePath)
-				}
-			case "update":
-				if err := mgr.Update(h); err != nil {
-					output.Error(h, remotePath, err)
-				} else {
-					output.OK(h, remotePath)
+			// reject typos before any host is contacted
+			if _, err := parseActions(h.Action); err != nil {
+				name := h.Name
+				if name == "" {
+					name = h.Host
 				}
-			default:
-				output.Error(h, remotePath, nil)
+				return nil, fmt.Errorf("server %s: %w", name, err)
 			}
+
+			out = append(out, h)
 		}
 	}
-	return nil
+	return out, nil
+}
+
+// usesDefaultKey reports whether any server deploys the default public key (no public_key or public_keys).
+func usesDefaultKey(hosts []config.Server) bool {
+	for _, h := range hosts {
+		if strings.TrimSpace(h.PublicKey) == "" && len(h.PublicKeys) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// selected reports whether srv matches the --limit list (empty list selects everything).
+func selected(limit string, srv config.Server) bool {
+	if strings.TrimSpace(limit) == "" {
+		return true
+	}
+	for _, l := range strings.Split(limit, ",") {
+		l = strings.TrimSpace(l)
+		if l != "" && (l == srv.Name || l == srv.Host || l == srv.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// runHost performs the configured actions for one resolved server. Several actions may be
+// given comma-separated (e.g. "inject,doctor"); they run in order over a single connection.
+func runHost(mgr *ops.KeyManager, opts *Options, h config.Server, out *output.Buffer) {
+	remotePath := hostRemotePath(h)
+
+	// If dry-run: don't perform actions, just print OK once
+	if opts.DryRun {
+		out.OK(h, remotePath)
+		return
+	}
+
+	actions, err := parseActions(h.Action)
+	if err != nil {
+		out.Error(h, remotePath, err)
+		return
+	}
+
+	host, err := mgr.Open(h)
+	if err != nil {
+		out.Error(h, remotePath, err)
+		return
+	}
+	defer func() {
+		if err := host.Close(); err != nil {
+			out.Error(h, remotePath, err)
+		}
+		if opts.Verbose {
+			out.Key(h, remotePath, "(timing)", host.Timings().String(), nil)
+		}
+	}()
+
+	if h.Locks() && changesHost(actions, h) {
+		if err := host.Lock(); err != nil {
+			out.Error(h, remotePath, err)
+			return
+		}
+	}
+
+	for _, action := range actions {
+		ha := h
+		ha.Action = action
+		runAction(host, ha, action, remotePath, out)
+	}
+}
+
+var knownActions = map[string]bool{
+	"inject": true, "add": true, "delete": true, "remove": true, "update": true, "rotate": true,
+	"check": true, "doctor": true, "prune-expired": true, "trust-ca": true, "list": true, "audit": true, "list-backups": true, "rollback": true,
+}
+
+// parseActions splits a comma-separated action list, rejecting unknown actions.
+func parseActions(action string) ([]string, error) {
+	var actions []string
+	for _, a := range strings.Split(action, ",") {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if !knownActions[a] {
+			if a == "" {
+				return nil, errors.New("no action given")
+			}
+			return nil, fmt.Errorf("unknown action %q", a)
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// changesHost reports whether any of actions may modify the host, and so needs the remote lock.
+func changesHost(actions []string, h config.Server) bool {
+	for _, a := range actions {
+		switch a {
+		case "check", "list", "audit", "list-backups":
+		case "doctor":
+			if h.FixesPermissions() {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// runAction performs one action on an open host and records its outcome.
+func runAction(host *ops.Host, h config.Server, action, remotePath string, out *output.Buffer) {
+	var results []ops.KeyResult
+	var err error
+	switch action {
+	case "inject", "add":
+		results, err = host.Inject()
+	case "delete", "remove":
+		results, err = host.Delete()
+	case "update":
+		results, err = host.Update()
+	case "rotate":
+		results, err = host.Rotate()
+	case "prune-expired":
+		results, err = host.PruneExpired()
+	case "trust-ca":
+		results, err := host.TrustCA()
+		reportKeys(out, h, ops.CAKeysFile(h), results, err)
+		return
+	case "check":
+		results, err := host.Check()
+		reportCheck(out, h, remotePath, results, err)
+		return
+	case "doctor":
+		issues, err := host.Doctor()
+		reportPermIssues(out, h, remotePath, issues, err)
+		return
+	case "list", "audit":
+		keys, err := host.List(remotePath)
+		reportKeyInfo(out, h, remotePath, keys, err)
+		return
+	case "list-backups":
+		backups, err := host.ListBackups()
+		reportBackups(out, h, remotePath, backups, err)
+		return
+	case "rollback":
+		b, err := host.Rollback(h.Backup)
+		if err != nil {
+			out.Error(h, remotePath, err)
+		} else {
+			out.Key(h, remotePath, b.Name, "restored", nil)
+		}
+		return
+	}
+	reportKeys(out, h, remotePath, results, err)
+}
+
+// reportKeys records one line per key, followed by a host-level error line if the operation failed.
+func reportKeys(out *output.Buffer, h config.Server, remotePath string, results []ops.KeyResult, err error) {
+	for _, r := range results {
+		label := r.Source
+		if r.Fingerprint != "" && label == "inline" {
+			label = r.Fingerprint
+		}
+		detail := r.Status
+		if len(r.Replaced) > 0 {
+			detail += " " + strings.Join(r.Replaced, ", ")
+		}
+		if !r.Expired.IsZero() {
+			detail += " " + authkeys.FormatExpires(r.Expired)
+		}
+		out.Key(h, remotePath, label, detail, r.Err)
+	}
+	if err != nil {
+		out.Error(h, remotePath, err)
+	} else if len(results) == 0 {
+		out.OK(h, remotePath)
+	}
+}
+
+// reportCheck records one line per key for a drift check; missing, options-differ and unexpected keys are drift.
+func reportCheck(out *output.Buffer, h config.Server, remotePath string, results []ops.KeyResult, err error) {
+	for _, r := range results {
+		label := r.Source
+		if r.Fingerprint != "" && label == "inline" {
+			label = r.Fingerprint
+		}
+		if ops.IsDrift(r.Status) {
+			out.Drift(h, remotePath, label, r.Status)
+		} else {
+			out.Key(h, remotePath, label, r.Status, r.Err)
+		}
+	}
+	if err != nil {
+		out.Error(h, remotePath, err)
+	}
+}
+
+// reportPermIssues records one line per StrictModes violation: fixed ones as OK,
+// failed fixes as errors and unfixed ones as drift.
+func reportPermIssues(out *output.Buffer, h config.Server, remotePath string, issues []ops.PermIssue, err error) {
+	for _, is := range issues {
+		switch {
+		case is.Err != nil:
+			out.Key(h, remotePath, is.Path, is.Problem, is.Err)
+		case is.Fixed:
+			out.Key(h, remotePath, is.Path, "fixed: "+is.Problem, nil)
+		default:
+			out.Drift(h, remotePath, is.Path, is.Problem)
+		}
+	}
+	if err != nil {
+		out.Error(h, remotePath, err)
+	} else if len(issues) == 0 {
+		out.Key(h, remotePath, "(permissions)", "ok", nil)
+	}
+}
+
+// reportBackups records one line per backup (newest first) with its age.
+func reportBackups(out *output.Buffer, h config.Server, remotePath string, backups []ops.Backup, err error) {
+	if err != nil {
+		out.Error(h, remotePath, err)
+		return
+	}
+	if len(backups) == 0 {
+		out.Key(h, remotePath, "(none)", "no backups", nil)
+		return
+	}
+	for _, b := range backups {
+		age := time.Since(b.Time).Round(time.Minute)
+		out.Key(h, remotePath, b.Name, fmt.Sprintf("age %s", age), nil)
+	}
+}
+
+// reportKeyInfo records one line per remote authorized_keys entry for list/audit.
+func reportKeyInfo(out *output.Buffer, h config.Server, remotePath string, keys []ops.KeyInfo, err error) {
+	if err != nil {
+		out.Error(h, remotePath, err)
+		return
+	}
+	if len(keys) == 0 {
+		out.Key(h, remotePath, "(none)", "no keys", nil)
+		return
+	}
+	for _, ki := range keys {
+		if ki.Err != nil {
+			out.Key(h, remotePath, fmt.Sprintf("line %d", ki.Line), "unparseable", ki.Err)
+			continue
+		}
+		out.Key(h, remotePath, ki.Fingerprint, describeKeyInfo(ki), nil)
+	}
+}
+
+// describeKeyInfo renders the audit detail: line, type, bits, comment, options and block membership.
+func describeKeyInfo(ki ops.KeyInfo) string {
+	parts := []string{fmt.Sprintf("line %d", ki.Line), ki.Type}
+	if ki.Bits > 0 {
+		parts = append(parts, fmt.Sprintf("%d bits", ki.Bits))
+	}
+	if ki.Comment != "" {
+		parts = append(parts, ki.Comment)
+	}
+	if len(ki.Options) > 0 {
+		parts = append(parts, "options="+strings.Join(ki.Options, ","))
+	}
+	if ki.Managed {
+		parts = append(parts, "managed")
+	}
+	return strings.Join(parts, " | ")
 }
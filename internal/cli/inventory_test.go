@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseActions(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{in: "inject", want: []string{"inject"}},
+		{in: " Inject , doctor", want: []string{"inject", "doctor"}},
+		{in: "check,list-backups,rollback", want: []string{"check", "list-backups", "rollback"}},
+		{in: "injct", wantErr: `unknown action "injct"`},
+		{in: "inject,,check", wantErr: "no action given"},
+		{in: "", wantErr: "no action given"},
+	}
+	for _, tt := range tests {
+		got, err := parseActions(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseActions(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseActions(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
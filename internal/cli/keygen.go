@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/keygen"
+	"github.com/thineshsubramani/sync-ssh-id/internal/util"
+)
+
+// RunKeygen implements "sync-ssh-id keygen [flags]": it creates a new key pair, by default
+// ~/.ssh/id_ed25519 and ~/.ssh/id_ed25519.pub.
+func RunKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	typ := fs.String("t", keygen.TypeEd25519, "key type: ed25519 or rsa")
+	bits := fs.Int("b", keygen.DefaultRSABits, "RSA key size in bits")
+	comment := fs.String("C", "", "key comment (default user@hostname)")
+	file := fs.String("f", "", "private key path; the public key is written to FILE.pub (default ~/.ssh/id_<type>)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sync-ssh-id keygen [-t ed25519|rsa] [-b bits] [-C comment] [-f file]\n"+
+			"The private key is encrypted with KEY_PASSPHRASE when it is set.\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	path := util.ExpandPath(*file)
+	if path == "" {
+		path = filepath.Join(util.SSHDir(), keygen.DefaultName(strings.ToLower(*typ)))
+	}
+	return generateKey(path, keygen.Options{Type: *typ, Bits: *bits, Comment: *comment})
+}
+
+// generateIfMissing creates the key pair for the public key pubPath when pubPath does not exist
+// (--generate-if-missing): RSA-4096 if the file name says rsa, ed25519 otherwise.
+func generateIfMissing(pubPath string) error {
+	if _, err := os.Stat(pubPath); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return nil // present, or unreadable for a reason generating would not fix
+	}
+	if !strings.HasSuffix(pubPath, ".pub") {
+		return fmt.Errorf("public key not found: %s (can only generate a missing *.pub)", pubPath)
+	}
+	return generateKey(strings.TrimSuffix(pubPath, ".pub"), keygen.Options{Type: keygen.TypeFor(pubPath)})
+}
+
+func generateKey(path string, o keygen.Options) error {
+	o.Passphrase = os.Getenv("KEY_PASSPHRASE")
+	pub, err := keygen.Generate(path, o)
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	log.Printf("[keygen] %s and %s.pub created (%s %s)", path, path, pub.Type(), ssh.FingerprintSHA256(pub))
+	return nil
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateIfMissingUsesKeyPassphrase(t *testing.T) {
+	captureLog(t)
+	t.Setenv("KEY_PASSPHRASE", "s3cret")
+	pubPath := filepath.Join(t.TempDir(), "id_ed25519.pub")
+	if err := generateIfMissing(pubPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(pubPath), "id_ed25519"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssh.ParsePrivateKey(data); err == nil {
+		t.Fatal("private key is not encrypted")
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("type = %s", signer.PublicKey().Type())
+	}
+
+	// present: left alone
+	before, _ := os.ReadFile(pubPath)
+	if err := generateIfMissing(pubPath); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(pubPath); string(after) != string(before) {
+		t.Error("existing public key rewritten")
+	}
+}
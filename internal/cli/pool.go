@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"sync"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+	"github.com/thineshsubramani/sync-ssh-id/internal/output"
+)
+
+// hostFunc processes one fully-resolved server and records its status lines in out.
+type hostFunc func(ctx context.Context, h config.Server, out *output.Buffer)
+
+// hostResult is what a worker hands back for the host at index idx.
+// A nil buf means the host was never started (shutdown was requested first).
+type hostResult struct {
+	idx int
+	buf *output.Buffer
+}
+
+// runPool runs fn for every host using at most workers goroutines.
+// Output is flushed in inventory order as soon as every earlier host has finished,
+// so lines from different hosts never interleave. Once ctx is cancelled no new
+// hosts are started; hosts already in flight are allowed to finish.
+// It returns the number of hosts that reported an error, the number that reported drift
+// without an error, and the number skipped.
+func runPool(ctx context.Context, hosts []config.Server, workers int, fn hostFunc) (failed, drifted, skipped int) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(hosts) {
+		workers = len(hosts)
+	}
+
+	jobs := make(chan int)
+	results := make(chan hostResult)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				if ctx.Err() != nil {
+					results <- hostResult{idx: idx}
+					continue
+				}
+				buf := &output.Buffer{}
+				fn(ctx, hosts[idx], buf)
+				results <- hostResult{idx: idx, buf: buf}
+			}
+		}()
+	}
+
+	go func() {
+		for i := range hosts {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// collect out of order, print in order
+	pending := make(map[int]*output.Buffer)
+	done := make(map[int]bool)
+	next := 0
+	for r := range results {
+		pending[r.idx] = r.buf
+		done[r.idx] = true
+		for done[next] {
+			if buf := pending[next]; buf != nil {
+				if buf.Failed() {
+					failed++
+				} else if buf.Drifted() {
+					drifted++
+				}
+				buf.Flush()
+			} else {
+				skipped++
+			}
+			delete(pending, next)
+			delete(done, next)
+			next++
+		}
+	}
+	return failed, drifted, skipped
+}
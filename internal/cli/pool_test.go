@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+	"github.com/thineshsubramani/sync-ssh-id/internal/output"
+)
+
+// captureLog redirects the standard logger, which output prints to, for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	flags, w := log.Flags(), log.Writer()
+	log.SetFlags(0)
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetFlags(flags)
+		log.SetOutput(w)
+	})
+	return &buf
+}
+
+// loggedHosts returns the host column of every captured line, in order.
+func loggedHosts(buf *bytes.Buffer) []string {
+	var hosts []string
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if f := strings.Fields(l); len(f) > 2 {
+			hosts = append(hosts, f[2]) // date, time, host
+		}
+	}
+	return hosts
+}
+
+func testHosts(n int) []config.Server {
+	hosts := make([]config.Server, n)
+	for i := range hosts {
+		hosts[i] = config.Server{Name: fmt.Sprintf("h%d", i), Host: fmt.Sprintf("h%d", i), Action: "check"}
+	}
+	return hosts
+}
+
+func TestRunPoolOrderAndCounts(t *testing.T) {
+	logged := captureLog(t)
+	hosts := testHosts(5)
+
+	// every host is in flight at once and they finish last to first
+	gates := make([]chan struct{}, len(hosts))
+	for i := range gates {
+		gates[i] = make(chan struct{})
+	}
+	close(gates[len(hosts)-1])
+	var mu sync.Mutex
+	var finished []string
+
+	failed, drifted, skipped := runPool(context.Background(), hosts, len(hosts), func(ctx context.Context, h config.Server, out *output.Buffer) {
+		var i int
+		fmt.Sscanf(h.Name, "h%d", &i)
+		<-gates[i]
+		switch i {
+		case 0:
+			out.Error(h, "~/.ssh/authorized_keys", errors.New("unreachable"))
+		case 1:
+			out.Drift(h, "~/.ssh/authorized_keys", "alice", "missing")
+		case 2:
+			out.Drift(h, "~/.ssh/authorized_keys", "alice", "missing")
+			out.Key(h, "~/.ssh/authorized_keys", "bob", "", errors.New("unreadable"))
+		default:
+			out.OK(h, "~/.ssh/authorized_keys")
+			out.OK(h, "~/.ssh/authorized_keys")
+		}
+		mu.Lock()
+		finished = append(finished, h.Name)
+		mu.Unlock()
+		if i > 0 {
+			close(gates[i-1])
+		}
+	})
+
+	if want := []string{"h4", "h3", "h2", "h1", "h0"}; !reflect.DeepEqual(finished, want) {
+		t.Fatalf("hosts finished in order %v, want %v", finished, want)
+	}
+	if want := []string{"h0", "h1", "h2", "h2", "h3", "h3", "h4", "h4"}; !reflect.DeepEqual(loggedHosts(logged), want) {
+		t.Errorf("output order %v, want %v", loggedHosts(logged), want)
+	}
+	if failed != 2 || drifted != 1 || skipped != 0 {
+		t.Errorf("failed, drifted, skipped = %d, %d, %d; want 2, 1, 0", failed, drifted, skipped)
+	}
+}
+
+func TestRunPoolCancelled(t *testing.T) {
+	logged := captureLog(t)
+	hosts := testHosts(4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ran []string
+	failed, drifted, skipped := runPool(ctx, hosts, 1, func(ctx context.Context, h config.Server, out *output.Buffer) {
+		ran = append(ran, h.Name)
+		if h.Name == "h1" {
+			cancel() // Ctrl-C while h1 is in flight: h1 finishes, h2 and h3 never start
+		}
+		out.OK(h, "~/.ssh/authorized_keys")
+	})
+
+	if want := []string{"h0", "h1"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if want := []string{"h0", "h1"}; !reflect.DeepEqual(loggedHosts(logged), want) {
+		t.Errorf("output %v, want %v", loggedHosts(logged), want)
+	}
+	if failed != 0 || drifted != 0 || skipped != 2 {
+		t.Errorf("failed, drifted, skipped = %d, %d, %d; want 0, 0, 2", failed, drifted, skipped)
+	}
+}
+
+func TestRunPoolMoreWorkersThanHosts(t *testing.T) {
+	captureLog(t)
+	failed, drifted, skipped := runPool(context.Background(), testHosts(2), 16, func(ctx context.Context, h config.Server, out *output.Buffer) {
+		out.OK(h, "~/.ssh/authorized_keys")
+	})
+	if failed != 0 || drifted != 0 || skipped != 0 {
+		t.Errorf("failed, drifted, skipped = %d, %d, %d; want 0, 0, 0", failed, drifted, skipped)
+	}
+}
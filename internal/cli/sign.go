@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/certs"
+	"github.com/thineshsubramani/sync-ssh-id/internal/util"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// RunSign implements "sync-ssh-id sign [flags] key.pub...": each user public key is signed
+// with the CA key and the certificate is written next to it as <key>-cert.pub.
+func RunSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	caPath := fs.String("ca", "", "CA private key (if encrypted, the passphrase is read from CA_PASSPHRASE)")
+	keyID := fs.String("id", "", "certificate key ID, e.g. alice@corp (required)")
+	principals := fs.String("principals", "", "comma-separated user names the certificate is valid for (required)")
+	validity := fs.String("valid", "-5m:+52w", "validity window in ssh-keygen -V syntax, e.g. +8h, -5m:+52w, 20250101:20251231, always:forever")
+	serial := fs.Uint64("serial", 0, "certificate serial number")
+	out := fs.String("out", "", "certificate path (a single key only; default <key>-cert.pub)")
+	var options stringList
+	fs.Var(&options, "O", "certificate option in ssh-keygen -O syntax, repeatable: clear, force-command=CMD, source-address=CIDRS, verify-required, no-pty, permit-pty, critical:NAME=VALUE, extension:NAME=VALUE, ...")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sync-ssh-id sign -ca CA_KEY -id KEY_ID -principals USER[,USER...] [flags] KEY.pub...\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	keys := fs.Args()
+	switch {
+	case *caPath == "":
+		return fmt.Errorf("-ca is required")
+	case len(keys) == 0:
+		return fmt.Errorf("no public keys to sign")
+	case *out != "" && len(keys) > 1:
+		return fmt.Errorf("-out needs exactly one public key")
+	}
+
+	ca, err := loadCASigner(util.ExpandPath(*caPath))
+	if err != nil {
+		return err
+	}
+
+	req := certs.NewRequest(strings.TrimSpace(*keyID))
+	req.Serial = *serial
+	for _, p := range strings.Split(*principals, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			req.Principals = append(req.Principals, p)
+		}
+	}
+	if req.ValidAfter, req.ValidBefore, err = certs.ParseValidity(*validity, time.Now()); err != nil {
+		return err
+	}
+	for _, o := range options {
+		if err := req.ApplyOption(o); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range keys {
+		path := util.ExpandPath(k)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read public key: %w", err)
+		}
+		pub, err := authkeys.ParsePublicKey(data)
+		if err != nil {
+			return fmt.Errorf("invalid public key %s: %w", path, err)
+		}
+		cert, err := certs.Sign(ca, pub.Key, req)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		dst := *out
+		if dst == "" {
+			dst = strings.TrimSuffix(path, ".pub") + "-cert.pub"
+		}
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+		if pub.Comment != "" {
+			line += " " + pub.Comment
+		}
+		if err := os.WriteFile(dst, []byte(line+"\n"), 0o644); err != nil {
+			return fmt.Errorf("write certificate: %w", err)
+		}
+		log.Printf("[sign] %s: key ID %q, serial %d, principals %s, valid %s", dst, req.KeyID, req.Serial,
+			strings.Join(req.Principals, ","), describeValidity(req.ValidAfter, req.ValidBefore))
+	}
+	return nil
+}
+
+// loadCASigner reads the CA private key, decrypting it with CA_PASSPHRASE when it is protected.
+func loadCASigner(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		pass := os.Getenv("CA_PASSPHRASE")
+		if pass == "" {
+			return nil, fmt.Errorf("CA key %s is passphrase protected; set CA_PASSPHRASE", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(pass))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse CA key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// describeValidity renders a validity window like ssh-keygen -L, e.g. "from 2025-01-01T12:00:00 to forever".
+func describeValidity(after, before time.Time) string {
+	from, to := "always", "forever"
+	if !after.IsZero() {
+		from = after.Local().Format("2006-01-02T15:04:05")
+	}
+	if !before.IsZero() {
+		to = before.Local().Format("2006-01-02T15:04:05")
+	}
+	return "from " + from + " to " + to
+}
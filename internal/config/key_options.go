@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeyOptions are the authorized_keys restrictions written in front of a key
+// (see the AUTHORIZED_KEYS FILE FORMAT section of sshd(8)).
+type KeyOptions struct {
+	From              []string `yaml:"from"`                // from="pattern-list"
+	Command           string   `yaml:"command"`             // command="..." forced command
+	Restrict          bool     `yaml:"restrict"`            // restrict (disable everything not re-enabled)
+	NoPty             bool     `yaml:"no_pty"`              // no-pty
+	NoPortForwarding  bool     `yaml:"no_port_forwarding"`  // no-port-forwarding
+	NoAgentForwarding bool     `yaml:"no_agent_forwarding"` // no-agent-forwarding
+	NoX11Forwarding   bool     `yaml:"no_x11_forwarding"`   // no-X11-forwarding
+	NoUserRC          bool     `yaml:"no_user_rc"`          // no-user-rc
+	PermitOpen        []string `yaml:"permitopen"`          // permitopen="host:port"
+	Environment       []string `yaml:"environment"`         // environment="NAME=value"
+	ExpiryTime        string   `yaml:"expiry_time"`         // expiry-time="YYYYMMDD[HHMM[SS]][Z]"
+}
+
+var (
+	expiryTimeRe = regexp.MustCompile(`^\d{8}(\d{4}(\d{2})?)?Z?$`)
+	envNameRe    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// Validate checks option values before they are rendered into an authorized_keys line.
+func (o *KeyOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	check := func(name, v string) error {
+		if strings.TrimSpace(v) == "" {
+			return fmt.Errorf("options.%s: empty value", name)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("options.%s: value must be a single line", name)
+		}
+		if strings.HasSuffix(v, `\`) {
+			// rendered as `\"`, which sshd reads as an escaped quote rather than the closing one
+			return fmt.Errorf("options.%s: value must not end with a backslash", name)
+		}
+		return nil
+	}
+	for _, f := range o.From {
+		if err := check("from", f); err != nil {
+			return err
+		}
+		if strings.ContainsAny(f, "\" ") {
+			return fmt.Errorf("options.from: invalid pattern %q", f)
+		}
+	}
+	if o.Command != "" {
+		if err := check("command", o.Command); err != nil {
+			return err
+		}
+	}
+	for _, p := range o.PermitOpen {
+		if err := check("permitopen", p); err != nil {
+			return err
+		}
+		i := strings.LastIndex(p, ":")
+		if i <= 0 || i == len(p)-1 || strings.ContainsAny(p, "\" ") {
+			return fmt.Errorf("options.permitopen: %q must be host:port", p)
+		}
+	}
+	for _, e := range o.Environment {
+		if err := check("environment", e); err != nil {
+			return err
+		}
+		if !envNameRe.MatchString(e) {
+			return fmt.Errorf("options.environment: %q must be NAME=value", e)
+		}
+	}
+	if o.ExpiryTime != "" && !expiryTimeRe.MatchString(o.ExpiryTime) {
+		return fmt.Errorf("options.expiry_time: %q must be YYYYMMDD[HHMM[SS]][Z]", o.ExpiryTime)
+	}
+	return nil
+}
+
+// Strings renders the options in authorized_keys syntax, in a fixed order so that
+// the same configuration always produces the same line.
+func (o *KeyOptions) Strings() []string {
+	if o == nil {
+		return nil
+	}
+	var out []string
+	if o.Restrict {
+		out = append(out, "restrict")
+	}
+	if len(o.From) > 0 {
+		out = append(out, quoteOption("from", strings.Join(o.From, ",")))
+	}
+	if o.Command != "" {
+		out = append(out, quoteOption("command", o.Command))
+	}
+	for _, e := range o.Environment {
+		out = append(out, quoteOption("environment", e))
+	}
+	for _, p := range o.PermitOpen {
+		out = append(out, quoteOption("permitopen", p))
+	}
+	if o.ExpiryTime != "" {
+		out = append(out, quoteOption("expiry-time", o.ExpiryTime))
+	}
+	if o.NoPty {
+		out = append(out, "no-pty")
+	}
+	if o.NoPortForwarding {
+		out = append(out, "no-port-forwarding")
+	}
+	if o.NoAgentForwarding {
+		out = append(out, "no-agent-forwarding")
+	}
+	if o.NoX11Forwarding {
+		out = append(out, "no-X11-forwarding")
+	}
+	if o.NoUserRC {
+		out = append(out, "no-user-rc")
+	}
+	return out
+}
+
+// quoteOption renders name="value", escaping embedded double quotes as sshd expects.
+func quoteOption(name, value string) string {
+	return name + `="` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
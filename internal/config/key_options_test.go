@@ -0,0 +1,72 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *KeyOptions
+		wantErr string
+	}{
+		{name: "nil", opts: nil},
+		{name: "typical", opts: &KeyOptions{From: []string{"10.0.0.0/8", "!10.0.0.1"}, Command: `/usr/bin/backup "$HOME"`,
+			Restrict: true, PermitOpen: []string{"db:5432"}, Environment: []string{"ROLE=ci"}, ExpiryTime: "20300101Z"}},
+		{name: "backslash inside command", opts: &KeyOptions{Command: `printf 'a\nb'`}},
+		{name: "command ending in backslash", opts: &KeyOptions{Command: `echo \`}, wantErr: "options.command: value must not end with a backslash"},
+		{name: "environment ending in backslash", opts: &KeyOptions{Environment: []string{`DIR=C:\`}}, wantErr: "options.environment: value must not end with a backslash"},
+		{name: "from ending in backslash", opts: &KeyOptions{From: []string{`host\`}}, wantErr: "options.from: value must not end with a backslash"},
+		{name: "multi-line command", opts: &KeyOptions{Command: "a\nb"}, wantErr: "single line"},
+		{name: "blank command", opts: &KeyOptions{Command: "  "}, wantErr: "empty value"},
+		{name: "from with quote", opts: &KeyOptions{From: []string{`a"b`}}, wantErr: "invalid pattern"},
+		{name: "permitopen without port", opts: &KeyOptions{PermitOpen: []string{"db:"}}, wantErr: "must be host:port"},
+		{name: "environment without name", opts: &KeyOptions{Environment: []string{"=x"}}, wantErr: "must be NAME=value"},
+		{name: "bad expiry", opts: &KeyOptions{ExpiryTime: "2030-01-01"}, wantErr: "must be YYYYMMDD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyOptionsStrings(t *testing.T) {
+	o := &KeyOptions{
+		From:        []string{"10.0.0.0/8", "*.corp"},
+		Command:     `echo "hi" \ there`,
+		Restrict:    true,
+		NoPty:       true,
+		NoUserRC:    true,
+		PermitOpen:  []string{"db:5432"},
+		Environment: []string{"A=1"},
+		ExpiryTime:  "20300101Z",
+	}
+	want := []string{
+		"restrict",
+		`from="10.0.0.0/8,*.corp"`,
+		`command="echo \"hi\" \ there"`,
+		`environment="A=1"`,
+		`permitopen="db:5432"`,
+		`expiry-time="20300101Z"`,
+		"no-pty",
+		"no-user-rc",
+	}
+	if got := o.Strings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Strings() =\n%q\nwant\n%q", got, want)
+	}
+	if got := (*KeyOptions)(nil).Strings(); got != nil {
+		t.Errorf("nil Strings() = %q", got)
+	}
+}
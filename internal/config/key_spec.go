@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeySpec is one entry of a server's public_keys list: a local path, a glob, an inline key
+// string or an HTTPS keys endpoint, optionally with its own authorized_keys options.
+//
+//	public_keys:
+//	  - ~/.ssh/id_ed25519.pub
+//	  - keys/team/*.pub
+//	  - "ssh-ed25519 AAAA... alice@corp"
+//	  - key: ~/.ssh/ci.pub
+//	    options: {restrict: true}
+//	  - key: ~/.ssh/alice.pub
+//	    key_id: alice
+//	  - key: keys/contractor.pub
+//	    expires: 2025-06-30
+//	  - https://git.corp/alice.keys
+//	  - url: https://git.corp/bob.keys
+//	    fingerprints: [SHA256:...]
+type KeySpec struct {
+	Key     string      `yaml:"key"`
+	Options *KeyOptions `yaml:"options,omitempty"` // overrides the server-level options for this key
+	KeyID   string      `yaml:"key_id,omitempty"`  // identity annotation written into the key's comment
+	Expires string      `yaml:"expires,omitempty"` // YYYY-MM-DD or RFC 3339; sets expiry-time and an expires annotation
+
+	URL          string   `yaml:"url,omitempty"`          // HTTPS endpoint serving authorized_keys lines, e.g. https://git.corp/alice.keys
+	Fingerprints []string `yaml:"fingerprints,omitempty"` // url: only these keys may be served (SHA256:...)
+}
+
+// Source returns where the entry's keys come from: its url, else its key.
+func (k KeySpec) Source() string {
+	if strings.TrimSpace(k.URL) != "" {
+		return k.URL
+	}
+	return k.Key
+}
+
+// UnmarshalYAML accepts either a plain string or a {key|url, options, key_id, expires, fingerprints} mapping.
+func (k *KeySpec) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		k.Key = n.Value
+		return nil
+	case yaml.MappingNode:
+		type plain KeySpec
+		if err := n.Decode((*plain)(k)); err != nil {
+			return err
+		}
+		switch {
+		case strings.TrimSpace(k.Key) != "" && strings.TrimSpace(k.URL) != "":
+			return fmt.Errorf("public_keys: line %d: key and url are mutually exclusive", n.Line)
+		case len(k.Fingerprints) > 0 && strings.TrimSpace(k.URL) == "":
+			return fmt.Errorf("public_keys: line %d: fingerprints need a url", n.Line)
+		}
+		return nil
+	default:
+		return fmt.Errorf("public_keys: line %d: expected a string or a mapping", n.Line)
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,14 +19,175 @@ type Server struct {
 	User      string `yaml:"user"`
 	Port      string `yaml:"port"`
 	PublicKey string `yaml:"public_key"`
-	Action    string `yaml:"action"`   // inject|delete|update
+	Action    string `yaml:"action"`   // inject|delete|update|rotate|check|doctor|prune-expired|trust-ca|list|list-backups|rollback, comma-separated for several
 	Pass      string `yaml:"pass"`     // legacy / short form
 	Password  string `yaml:"password"` // support full 'password:' key in YAML
+
+	PublicKeys   []KeySpec   `yaml:"public_keys,omitempty"`   // extra keys applied over the same connection
+	KeyOptions   *KeyOptions `yaml:"options,omitempty"`       // authorized_keys restrictions for this server's keys
+	Exclusive    *bool       `yaml:"exclusive,omitempty"`     // remove every key not listed (nil = inventory default)
+	ManagedBlock *bool       `yaml:"managed_block,omitempty"` // keep keys between BEGIN/END markers (nil = inventory default)
+	Transport    string      `yaml:"transport,omitempty"`     // sftp|shell|auto ("" = inventory default)
+	Backup       string      `yaml:"backup,omitempty"`        // rollback source: backup name or timestamp ("" = newest)
+	OldKey       string      `yaml:"old_key,omitempty"`       // rotate: key to remove once new_key is verified
+	NewKey       string      `yaml:"new_key,omitempty"`       // rotate: key to install (private key expected next to it)
+	TargetUser   string      `yaml:"target_user,omitempty"`   // manage this user's authorized_keys instead of the login user's
+	Become       string      `yaml:"become,omitempty"`        // how to switch to target_user: sudo
+	BecomePass   string      `yaml:"become_pass,omitempty"`   // sudo password (default: SUDO_PASS, then the login password)
+	RemotePath   string      `yaml:"remote_path,omitempty"`   // authorized_keys path, or "auto" to ask sshd ("" = inventory default)
+	KeyID        string      `yaml:"key_id,omitempty"`        // key_id annotation for public_key
+	Expires      string      `yaml:"expires,omitempty"`       // expiry of public_key (YYYY-MM-DD or RFC 3339)
+	CAKey        string      `yaml:"ca_key,omitempty"`        // trust-ca: user CA public key(s) to trust ("" = inventory default)
+	CAKeysFile   string      `yaml:"ca_keys_file,omitempty"`  // trust-ca: TrustedUserCAKeys file ("" = inventory default)
+	Identity     string      `yaml:"identity,omitempty"`      // update replaces older keys with the same comment|key_id ("" = inventory default)
+
+	ResetKnownHost       *bool `yaml:"reset_knownhost,omitempty"`        // drop local known_hosts entries before dialing
+	BackupAuthorizedKeys *bool `yaml:"backup_authorized_keys,omitempty"` // back up authorized_keys before changing it
+	Verify               *bool `yaml:"verify,omitempty"`                 // log in again with each deployed key afterwards
+	FixPermissions       *bool `yaml:"fix_permissions,omitempty"`        // doctor repairs StrictModes violations it finds
+	Lock                 *bool `yaml:"lock,omitempty"`                   // hold a remote lock while changing authorized_keys
+}
+
+// IsExclusive reports whether authorized_keys must be reconciled to exactly the configured keys.
+func (s Server) IsExclusive() bool {
+	return s.Exclusive != nil && *s.Exclusive
+}
+
+// UsesManagedBlock reports whether edits are confined to the sync-ssh-id managed block.
+func (s Server) UsesManagedBlock() bool {
+	return s.ManagedBlock != nil && *s.ManagedBlock
+}
+
+// ResetsKnownHost reports whether stale known_hosts entries are removed before dialing.
+func (s Server) ResetsKnownHost() bool {
+	return s.ResetKnownHost != nil && *s.ResetKnownHost
+}
+
+// BacksUpAuthorizedKeys reports whether authorized_keys is backed up before a change (default true).
+func (s Server) BacksUpAuthorizedKeys() bool {
+	return s.BackupAuthorizedKeys == nil || *s.BackupAuthorizedKeys
+}
+
+// Verifies reports whether deployed keys are verified with a fresh public-key login.
+func (s Server) Verifies() bool {
+	return s.Verify != nil && *s.Verify
+}
+
+// FixesPermissions reports whether doctor repairs the StrictModes violations it finds.
+func (s Server) FixesPermissions() bool {
+	return s.FixPermissions != nil && *s.FixPermissions
+}
+
+// Locks reports whether changes are made under the remote advisory lock (default true).
+func (s Server) Locks() bool {
+	return s.Lock == nil || *s.Lock
+}
+
+// ApplyDefaults fills per-server settings that were left unset from the inventory options.
+func (s *Server) ApplyDefaults(o Options) {
+	s.ResetKnownHost = boolDefault(s.ResetKnownHost, o.ResetKnownHost)
+	if o.BackupAuthorizedKeys != nil {
+		s.BackupAuthorizedKeys = boolDefault(s.BackupAuthorizedKeys, *o.BackupAuthorizedKeys)
+	}
+	if o.Lock != nil {
+		s.Lock = boolDefault(s.Lock, *o.Lock)
+	}
+	s.Exclusive = boolDefault(s.Exclusive, o.Exclusive)
+	s.ManagedBlock = boolDefault(s.ManagedBlock, o.ManagedBlock)
+	s.Verify = boolDefault(s.Verify, o.Verify)
+	s.FixPermissions = boolDefault(s.FixPermissions, o.FixPermissions)
+	if s.Transport == "" {
+		s.Transport = o.Transport
+	}
+	if s.RemotePath == "" {
+		s.RemotePath = o.RemotePath
+	}
+	if s.Identity == "" {
+		s.Identity = o.Identity
+	}
+	if s.CAKey == "" {
+		s.CAKey = o.CAKey
+	}
+	if s.CAKeysFile == "" {
+		s.CAKeysFile = o.CAKeysFile
+	}
+}
+
+func boolDefault(v *bool, def bool) *bool {
+	if v != nil {
+		return v
+	}
+	return &def
 }
 
 type Options struct {
-	ResetKnownHost       bool `yaml:"reset_knownhost"`
-	BackupAuthorizedKeys bool `yaml:"backup_authorized_keys"`
+	ResetKnownHost       bool   `yaml:"reset_knownhost"`
+	BackupAuthorizedKeys *bool  `yaml:"backup_authorized_keys"` // nil = true (back up before every change)
+	Concurrency          int    `yaml:"concurrency"`            // max hosts processed in parallel (default 1)
+	Exclusive            bool   `yaml:"exclusive"`              // default for servers without their own exclusive setting
+	ManagedBlock         bool   `yaml:"managed_block"`          // default for servers without their own managed_block setting
+	Transport            string `yaml:"transport"`              // sftp|shell|auto (default auto)
+	BackupKeep           int    `yaml:"backup_keep"`            // keep at most N authorized_keys backups per host (0 = all)
+	BackupMaxAge         string `yaml:"backup_max_age"`         // delete backups older than this, e.g. "720h" or "30d"
+	Verify               bool   `yaml:"verify"`                 // default for servers without their own verify setting
+	RemotePath           string `yaml:"remote_path"`            // default authorized_keys path or "auto" (default ~/.ssh/authorized_keys)
+	FixPermissions       bool   `yaml:"fix_permissions"`        // default for servers without their own fix_permissions setting
+	Identity             string `yaml:"identity"`               // default for servers without their own identity setting
+	Lock                 *bool  `yaml:"lock"`                   // nil = true (lock authorized_keys while changing it)
+	LockTimeout          string `yaml:"lock_timeout"`           // how long to wait for another run's lock (default 30s)
+	CAKey                string `yaml:"ca_key"`                 // default user CA public key for trust-ca
+	CAKeysFile           string `yaml:"ca_keys_file"`           // default TrustedUserCAKeys file (default /etc/ssh/trusted_user_ca_keys)
+	URLCacheMaxAge       string `yaml:"url_cache_max_age"`      // oldest cached url: key list used when the endpoint fails (default 24h)
+}
+
+// DefaultLockTimeout is how long a host waits for another run's lock when lock_timeout is unset.
+const DefaultLockTimeout = 30 * time.Second
+
+// DefaultURLCacheMaxAge is how old a cached url: key list may be when url_cache_max_age is unset.
+const DefaultURLCacheMaxAge = 24 * time.Hour
+
+// BackupMaxAgeDuration parses BackupMaxAge; it accepts Go durations plus a "d" (days) suffix.
+func (o Options) BackupMaxAgeDuration() (time.Duration, error) {
+	v := strings.TrimSpace(o.BackupMaxAge)
+	if v == "" {
+		return 0, nil
+	}
+	return parseDuration("options.backup_max_age", v)
+}
+
+// LockTimeoutDuration parses LockTimeout like BackupMaxAge; empty means DefaultLockTimeout.
+func (o Options) LockTimeoutDuration() (time.Duration, error) {
+	v := strings.TrimSpace(o.LockTimeout)
+	if v == "" {
+		return DefaultLockTimeout, nil
+	}
+	return parseDuration("options.lock_timeout", v)
+}
+
+// URLCacheMaxAgeDuration parses URLCacheMaxAge like BackupMaxAge; empty means DefaultURLCacheMaxAge
+// and 0 turns the cache fallback off.
+func (o Options) URLCacheMaxAgeDuration() (time.Duration, error) {
+	v := strings.TrimSpace(o.URLCacheMaxAge)
+	if v == "" {
+		return DefaultURLCacheMaxAge, nil
+	}
+	return parseDuration("options.url_cache_max_age", v)
+}
+
+// parseDuration accepts a non-negative Go duration or a whole number of days ("30d").
+func parseDuration(field, v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s: invalid value %q", field, v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s: invalid value %q", field, v)
+	}
+	return d, nil
 }
 
 type Inventory struct {
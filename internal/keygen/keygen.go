@@ -0,0 +1,156 @@
+// Package keygen creates OpenSSH key pairs the way ssh-keygen does.
+package keygen
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Key types Generate can create.
+const (
+	TypeEd25519 = "ed25519"
+	TypeRSA     = "rsa"
+)
+
+// DefaultRSABits is the RSA key size used when Options.Bits is 0.
+const DefaultRSABits = 4096
+
+// minRSABits is the smallest RSA key Generate accepts.
+const minRSABits = 2048
+
+// Options describes the key pair to create.
+type Options struct {
+	Type       string // TypeEd25519 (default) or TypeRSA
+	Bits       int    // RSA only; 0 = DefaultRSABits
+	Comment    string // "" = DefaultComment()
+	Passphrase string // "" = unencrypted private key
+}
+
+// DefaultName returns the file name ssh-keygen uses in ~/.ssh for typ, e.g. "id_ed25519".
+func DefaultName(typ string) string {
+	if typ == "" {
+		typ = TypeEd25519
+	}
+	return "id_" + typ
+}
+
+// TypeFor guesses the key type from a key file name: RSA for names like "id_rsa.pub", ed25519 otherwise.
+func TypeFor(path string) string {
+	if strings.Contains(strings.ToLower(filepath.Base(path)), TypeRSA) {
+		return TypeRSA
+	}
+	return TypeEd25519
+}
+
+// DefaultComment returns "user@hostname", like ssh-keygen.
+func DefaultComment() string {
+	name := "user"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+		if i := strings.LastIndex(name, `\`); i >= 0 {
+			name = name[i+1:] // DOMAIN\user on Windows
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
+
+// Generate creates a key pair: the private key at path (OpenSSH format, mode 0600) and the
+// public key at path+".pub" (mode 0644). A missing directory is created with mode 0700.
+// Existing files are never overwritten.
+func Generate(path string, o Options) (ssh.PublicKey, error) {
+	pubPath := path + ".pub"
+	for _, p := range []string{path, pubPath} {
+		if _, err := os.Stat(p); err == nil {
+			return nil, fmt.Errorf("%s already exists", p)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	priv, err := newKey(o)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	comment := strings.TrimSpace(o.Comment)
+	if comment == "" {
+		comment = DefaultComment()
+	}
+	var block *pem.Block
+	if o.Passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, comment, []byte(o.Passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, comment)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode private key: %w", err)
+	}
+	pubLine := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + comment + "\n"
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	if err := writeNew(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeNew(pubPath, []byte(pubLine), 0o644); err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
+
+func newKey(o Options) (crypto.PrivateKey, error) {
+	switch strings.ToLower(o.Type) {
+	case "", TypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case TypeRSA:
+		bits := o.Bits
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits < minRSABits {
+			return nil, fmt.Errorf("RSA keys need at least %d bits", minRSABits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+	return nil, fmt.Errorf("unsupported key type %q (want %s or %s)", o.Type, TypeEd25519, TypeRSA)
+}
+
+// writeNew writes data to a file that must not exist yet, with exactly mode perm.
+func writeNew(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Chmod(perm); err != nil { // the umask may have cleared bits of perm
+		_ = f.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("chmod %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,141 @@
+package keygen
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		o       Options
+		keyType string
+		comment string // "" = DefaultComment()
+	}{
+		{name: "defaults", keyType: ssh.KeyAlgoED25519},
+		{name: "ed25519 with comment", o: Options{Type: "ED25519", Comment: "  ci@build "}, keyType: ssh.KeyAlgoED25519, comment: "ci@build"},
+		{name: "rsa", o: Options{Type: TypeRSA, Bits: 2048, Comment: "alice"}, keyType: ssh.KeyAlgoRSA, comment: "alice"},
+		{name: "encrypted", o: Options{Comment: "alice", Passphrase: "correct horse"}, keyType: ssh.KeyAlgoED25519, comment: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "new", ".ssh")
+			path := filepath.Join(dir, DefaultName(tt.o.Type))
+			pub, err := Generate(path, tt.o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pub.Type() != tt.keyType {
+				t.Errorf("type = %s, want %s", pub.Type(), tt.keyType)
+			}
+
+			for p, want := range map[string]os.FileMode{dir: 0o700, path: 0o600, path + ".pub": 0o644} {
+				fi, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Mode().Perm() != want {
+					t.Errorf("%s: mode %04o, want %04o", p, fi.Mode().Perm(), want)
+				}
+			}
+
+			comment := tt.comment
+			if comment == "" {
+				comment = DefaultComment()
+			}
+			data, err := os.ReadFile(path + ".pub")
+			if err != nil {
+				t.Fatal(err)
+			}
+			filePub, fileComment, _, _, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileComment != comment {
+				t.Errorf("comment = %q, want %q", fileComment, comment)
+			}
+			if !bytes.Equal(filePub.Marshal(), pub.Marshal()) {
+				t.Error("public key file does not match the returned key")
+			}
+
+			data, err = os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var signer ssh.Signer
+			if tt.o.Passphrase != "" {
+				var missing *ssh.PassphraseMissingError
+				if _, err := ssh.ParsePrivateKey(data); !errors.As(err, &missing) {
+					t.Fatalf("private key is not encrypted: %v", err)
+				}
+				if _, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte("wrong")); err == nil {
+					t.Fatal("wrong passphrase accepted")
+				}
+				signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(tt.o.Passphrase))
+			} else {
+				signer, err = ssh.ParsePrivateKey(data)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+				t.Error("private key does not match the returned key")
+			}
+		})
+	}
+}
+
+func TestGenerateNeverOverwrites(t *testing.T) {
+	for _, existing := range []string{"id_ed25519", "id_ed25519.pub"} {
+		t.Run(existing, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, existing), []byte("keep me\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Generate(filepath.Join(dir, "id_ed25519"), Options{})
+			if err == nil || !strings.Contains(err.Error(), "already exists") {
+				t.Fatalf("err = %v, want already exists", err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("%d files in %s, want only %s", len(entries), dir, existing)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, existing)); string(data) != "keep me\n" {
+				t.Errorf("%s overwritten: %q", existing, data)
+			}
+		})
+	}
+}
+
+func TestGenerateInvalidOptions(t *testing.T) {
+	for _, o := range []Options{{Type: TypeRSA, Bits: 1024}, {Type: "dsa"}} {
+		dir := t.TempDir()
+		if _, err := Generate(filepath.Join(dir, "id"), o); err == nil {
+			t.Errorf("Generate(%+v) succeeded", o)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Generate(%+v) left %d files behind", o, len(entries))
+		}
+	}
+}
+
+func TestDefaultComment(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	if got, want := DefaultComment(), u.Username+"@"+host; got != want {
+		t.Errorf("DefaultComment() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package ops
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// KeyInfo describes one line of a remote authorized_keys file for auditing.
+type KeyInfo struct {
+	Line        int
+	Type        string
+	Bits        int
+	Fingerprint string
+	Comment     string
+	Options     []string
+	Managed     bool  // inside the sync-ssh-id managed block
+	Err         error // set for lines that are not valid keys
+}
+
+// List fetches and parses the remote authorized_keys (remotePath, default the server's remote_path)
+// without changing anything, and describes every key line and every unparseable line.
+func (k *KeyManager) List(s config.Server, remotePath string) ([]KeyInfo, error) {
+	return withHost(k, s, func(h *Host) ([]KeyInfo, error) { return h.List(remotePath) })
+}
+
+// List is KeyManager.List on an open connection.
+func (h *Host) List(remotePath string) ([]KeyInfo, error) {
+	var err error
+	if strings.TrimSpace(remotePath) == "" || remotePath == h.s.RemotePath {
+		remotePath, err = h.keysPath()
+	} else {
+		remotePath, err = h.k.authorizedKeysPath(h.client, h.fs, h.s, remotePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	done := h.phase("read")
+	data, err := h.fs.ReadFile(remotePath)
+	done()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", remotePath, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%s does not exist", remotePath)
+	}
+	return describeKeys(data), nil
+}
+
+// describeKeys turns authorized_keys content into KeyInfo records in file order,
+// flagging the entries that sit between the managed block markers.
+func describeKeys(data []byte) []KeyInfo {
+	var out []KeyInfo
+	managed := false
+	for _, e := range authkeys.Parse(data).Entries {
+		switch strings.TrimSpace(e.Raw) {
+		case authkeys.BeginMarker:
+			managed = true
+			continue
+		case authkeys.EndMarker:
+			managed = false
+			continue
+		}
+		switch {
+		case e.IsKey():
+			out = append(out, KeyInfo{
+				Line:        e.Line,
+				Type:        e.Type,
+				Bits:        e.Bits(),
+				Fingerprint: e.Fingerprint(),
+				Comment:     e.Comment,
+				Options:     e.Options,
+				Managed:     managed,
+			})
+		case e.Err != nil:
+			out = append(out, KeyInfo{Line: e.Line, Managed: managed, Err: e.Err})
+		}
+	}
+	return out
+}
@@ -13,29 +13,31 @@ import (
 	"golang.org/x/crypto/ssh/agent"
 )
 
-// privateKeyAuthMethods returns available local private key auth methods (publickeys)
+// privateKeyAuthMethods returns available local private key auth methods (publickeys), trying
+// the keys in OpenSSH's order: id_ed25519, id_ecdsa, id_rsa. They share one method because the
+// client gives up on "publickey" after the first method of that name.
 func privateKeyAuthMethods() []ssh.AuthMethod {
-	var out []ssh.AuthMethod
+	var signers []ssh.Signer
 	usr, err := user.Current()
 	if err != nil {
 		return nil
 	}
 	cands := []string{
-		filepath.Join(usr.HomeDir, ".ssh", "id_rsa"),
 		filepath.Join(usr.HomeDir, ".ssh", "id_ed25519"),
 		filepath.Join(usr.HomeDir, ".ssh", "id_ecdsa"),
+		filepath.Join(usr.HomeDir, ".ssh", "id_rsa"),
 	}
 	for _, p := range cands {
 		if _, err := os.Stat(p); err == nil {
 			if signer, err := readPrivateKeySigner(p); err == nil {
-				out = append(out, ssh.PublicKeys(signer))
+				signers = append(signers, signer)
 			}
 		}
 	}
-	if len(out) == 0 {
+	if len(signers) == 0 {
 		return nil
 	}
-	return out
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}
 }
 
 func readPrivateKeySigner(path string) (ssh.Signer, error) {
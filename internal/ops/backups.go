@@ -0,0 +1,160 @@
+package ops
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+const backupTimeFormat = "20060102T150405Z"
+
+// Backup is one authorized_keys.bak.<timestamp> file on a host.
+type Backup struct {
+	Name string    // file name, e.g. authorized_keys.bak.20250101T120000Z
+	Path string    // remote path
+	Time time.Time // parsed from the name (UTC)
+}
+
+// backupAuthorizedKeys copies the authorized_keys file target to target.bak.<timestamp>,
+// then applies the retention policy, never removing the backups at the paths in keep.
+// Retention failures are logged, not fatal.
+func (k *KeyManager) backupAuthorizedKeys(fs remoteFS, target string, keep ...string) error {
+	ts := time.Now().UTC().Format(backupTimeFormat)
+	if err := fs.Copy(target, target+".bak."+ts); err != nil {
+		return err
+	}
+	if _, err := k.pruneBackups(fs, target, keep...); err != nil {
+		log.Printf("backup retention: %v", err)
+	}
+	return nil
+}
+
+// listBackups returns the backups of target, newest first.
+func (k *KeyManager) listBackups(fs remoteFS, target string) ([]Backup, error) {
+	dir, prefix := path.Dir(target), path.Base(target)+".bak."
+	names, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", dir, err)
+	}
+	var out []Backup
+	for _, n := range names {
+		if !strings.HasPrefix(n, prefix) {
+			continue
+		}
+		ts, err := time.Parse(backupTimeFormat, strings.TrimPrefix(n, prefix))
+		if err != nil {
+			continue // not one of ours
+		}
+		out = append(out, Backup{Name: n, Path: dir + "/" + n, Time: ts})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+	return out, nil
+}
+
+// pruneBackups removes backups beyond BackupKeep and older than BackupMaxAge.
+// The newest backup and those at the paths in keep are always kept. It returns the removed backups.
+func (k *KeyManager) pruneBackups(fs remoteFS, target string, keep ...string) ([]Backup, error) {
+	if k.BackupKeep <= 0 && k.BackupMaxAge <= 0 {
+		return nil, nil
+	}
+	backups, err := k.listBackups(fs, target)
+	if err != nil {
+		return nil, err
+	}
+	var removed []Backup
+	for i, b := range backups {
+		if i == 0 {
+			continue
+		}
+		tooMany := k.BackupKeep > 0 && i >= k.BackupKeep
+		tooOld := k.BackupMaxAge > 0 && time.Since(b.Time) > k.BackupMaxAge
+		if !tooMany && !tooOld || slices.Contains(keep, b.Path) {
+			continue
+		}
+		if err := fs.Remove(b.Path); err != nil {
+			return removed, fmt.Errorf("remove %s: %w", b.Name, err)
+		}
+		removed = append(removed, b)
+	}
+	return removed, nil
+}
+
+// ListBackups returns the authorized_keys backups on the host, newest first.
+func (k *KeyManager) ListBackups(s config.Server) ([]Backup, error) {
+	return withHost(k, s, (*Host).ListBackups)
+}
+
+// ListBackups is KeyManager.ListBackups on an open connection.
+func (h *Host) ListBackups() ([]Backup, error) {
+	akPath, err := h.keysPath()
+	if err != nil {
+		return nil, err
+	}
+	return h.k.listBackups(h.fs, akPath)
+}
+
+// Rollback restores authorized_keys from a backup. name may be a full backup file name,
+// just its timestamp, or "" / "latest" for the newest backup. Unless backups are disabled,
+// the current file is backed up first so the rollback itself can be undone; the retention
+// run that follows leaves the restored backup alone.
+func (k *KeyManager) Rollback(s config.Server, name string) (Backup, error) {
+	return withLockedHost(k, s, func(h *Host) (Backup, error) { return h.Rollback(name) })
+}
+
+// Rollback is KeyManager.Rollback on an open connection.
+func (h *Host) Rollback(name string) (Backup, error) {
+	s, k, fs := h.s, h.k, h.fs
+	akPath, err := h.keysPath()
+	if err != nil {
+		return Backup{}, err
+	}
+	backups, err := k.listBackups(fs, akPath)
+	if err != nil {
+		return Backup{}, err
+	}
+	b, err := pickBackup(backups, name)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	data, err := fs.ReadFile(b.Path)
+	if err != nil {
+		return b, fmt.Errorf("read %s: %w", b.Name, err)
+	}
+	if data == nil {
+		return b, fmt.Errorf("backup %s disappeared", b.Name)
+	}
+
+	if s.BacksUpAuthorizedKeys() {
+		if err := k.backupAuthorizedKeys(fs, akPath, b.Path); err != nil {
+			return b, fmt.Errorf("backup authorized_keys: %w", err)
+		}
+	}
+	if err := k.remoteWriteAuthorizedKeys(fs, akPath, data); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// pickBackup selects the backup named by name (see Rollback) from a newest-first list.
+func pickBackup(backups []Backup, name string) (Backup, error) {
+	if len(backups) == 0 {
+		return Backup{}, fmt.Errorf("no backups found")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "latest") {
+		return backups[0], nil
+	}
+	for _, b := range backups {
+		if b.Name == name || strings.HasSuffix(b.Name, ".bak."+name) {
+			return b, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("backup %q not found", name)
+}
@@ -0,0 +1,80 @@
+package ops
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPruneBackups(t *testing.T) {
+	const ak = "/home/u/.ssh/authorized_keys"
+	now := time.Now().UTC()
+	name := func(age time.Duration) string { return ak + ".bak." + now.Add(-age).Format(backupTimeFormat) }
+	day := 24 * time.Hour
+	b0, b1, b2, b3 := name(0), name(day), name(10*day), name(40*day)
+
+	tests := []struct {
+		name   string
+		keep   int
+		maxAge time.Duration
+		spare  []string
+		want   []string // backups left
+	}{
+		{name: "no policy", want: []string{b0, b1, b2, b3}},
+		{name: "keep 2", keep: 2, want: []string{b0, b1}},
+		{name: "max age", maxAge: 30 * day, want: []string{b0, b1, b2}},
+		{name: "newest always kept", keep: 1, maxAge: time.Hour, want: []string{b0}},
+		{name: "restored backup spared", keep: 2, spare: []string{b3}, want: []string{b0, b1, b3}},
+		{name: "restored backup spared from age", maxAge: 5 * day, spare: []string{b2}, want: []string{b0, b1, b2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := mapFS{files: map[string]string{ak: "", b0: "", b1: "", b2: "", b3: "", ak + ".bak.junk": ""}}
+			k := &KeyManager{BackupKeep: tt.keep, BackupMaxAge: tt.maxAge}
+			if _, err := k.pruneBackups(fs, ak, tt.spare...); err != nil {
+				t.Fatal(err)
+			}
+			var left []string
+			for p := range fs.files {
+				if p != ak && p != ak+".bak.junk" {
+					left = append(left, p)
+				}
+			}
+			sort.Sort(sort.Reverse(sort.StringSlice(left)))
+			if !reflect.DeepEqual(left, tt.want) {
+				t.Errorf("left %v, want %v", left, tt.want)
+			}
+			if _, ok := fs.files[ak+".bak.junk"]; !ok {
+				t.Error("removed a file that is not a backup")
+			}
+		})
+	}
+}
+
+func TestPickBackup(t *testing.T) {
+	backups := []Backup{
+		{Name: "authorized_keys.bak.20250102T000000Z"},
+		{Name: "authorized_keys.bak.20250101T000000Z"},
+	}
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "", want: backups[0].Name},
+		{name: "latest", want: backups[0].Name},
+		{name: "20250101T000000Z", want: backups[1].Name},
+		{name: "authorized_keys.bak.20250101T000000Z", want: backups[1].Name},
+		{name: "20240101T000000Z", wantErr: true},
+	}
+	for _, tt := range tests {
+		b, err := pickBackup(backups, tt.name)
+		if (err != nil) != tt.wantErr || b.Name != tt.want {
+			t.Errorf("pickBackup(%q) = %q, %v; want %q", tt.name, b.Name, err, tt.want)
+		}
+	}
+	if _, err := pickBackup(nil, ""); err == nil {
+		t.Error("pickBackup on no backups succeeded")
+	}
+}
@@ -0,0 +1,87 @@
+package ops
+
+import (
+	"fmt"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// Drift check statuses reported per key.
+const (
+	KeyInSync        = "in-sync"
+	KeyMissing       = "missing"        // configured key not found remotely
+	KeyOptionsDiffer = "options-differ" // configured key present, but not with its configured options
+	KeyUnexpected    = "unexpected"     // remote key that exclusive mode would prune
+)
+
+// IsDrift reports whether a check status means the host does not match the inventory.
+func IsDrift(status string) bool {
+	return status == KeyMissing || status == KeyOptionsDiffer || status == KeyUnexpected
+}
+
+// Check compares the remote authorized_keys against the configured keys without changing anything.
+// Every configured key is reported in-sync, options-differ or missing; in exclusive mode every
+// other key in the editable scope (the managed block, or the whole file) is reported as unexpected.
+func (k *KeyManager) Check(s config.Server) ([]KeyResult, error) {
+	return withHost(k, s, (*Host).Check)
+}
+
+// Check is KeyManager.Check on an open connection.
+func (h *Host) Check() ([]KeyResult, error) {
+	s := h.s
+	keys, results := h.k.resolveKeys(s)
+	if len(keys) == 0 {
+		return results, fmt.Errorf("no usable public keys")
+	}
+
+	akPath, err := h.keysPath()
+	if err != nil {
+		return results, err
+	}
+	done := h.phase("read")
+	doc, err := h.k.remoteReadAuthorizedKeys(h.fs, akPath, s.UsesManagedBlock())
+	done()
+	if err != nil {
+		return results, fmt.Errorf("read authorized_keys: %w", err)
+	}
+
+	return append(results, checkKeys(doc, keys, s.IsExclusive())...), nil
+}
+
+// checkKeys reports the drift status of every configured key in doc and, with exclusive set,
+// of every other key in doc's editable scope. doc.Block may be modified.
+func checkKeys(doc *authkeys.Document, keys []LocalKey, exclusive bool) []KeyResult {
+	var results []KeyResult
+	for _, key := range keys {
+		status := KeyMissing
+		switch {
+		case hasKeyLine(doc.Block, key):
+			status = KeyInSync
+		case doc.Block.Contains(key.Key):
+			status = KeyOptionsDiffer // update would rewrite the line
+		case doc.Outside().Contains(key.Key):
+			status = KeyInSync // outside the managed block, which update leaves alone
+		}
+		results = append(results, KeyResult{Source: key.Source, Fingerprint: key.Key.Fingerprint(), Status: status})
+	}
+	if exclusive {
+		// pruneUnlisted only edits the in-memory copy, which is never written back
+		for _, r := range pruneUnlisted(doc.Block, keys) {
+			r.Status = KeyUnexpected
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// hasKeyLine reports whether f holds key with exactly its configured options.
+func hasKeyLine(f *authkeys.File, key LocalKey) bool {
+	want, _ := authkeys.ParseLine(key.Line)
+	for _, e := range f.Find(key.Key) {
+		if e.HasOptions(want.Options) {
+			return true
+		}
+	}
+	return false
+}
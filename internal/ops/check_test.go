@@ -0,0 +1,58 @@
+package ops
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+)
+
+func TestCheckKeys(t *testing.T) {
+	block := func(lines string) string {
+		return "# by hand\n" + carolKey + " carol\n" + authkeys.BeginMarker + "\n" + lines + authkeys.EndMarker + "\n"
+	}
+	tests := []struct {
+		name      string
+		file      string
+		managed   bool
+		exclusive bool
+		keys      []LocalKey
+		want      []string
+	}{
+		{name: "in sync", file: `from="10.0.0.0/8" ` + aliceKey + " alice\n",
+			keys: []LocalKey{localKey(t, aliceKey+" alice", `from="10.0.0.0/8"`)}, want: []string{"alice: in-sync"}},
+		{name: "comment differs", file: aliceKey + " alice@old\n",
+			keys: []LocalKey{localKey(t, aliceKey+" alice")}, want: []string{"alice: in-sync"}},
+		{name: "missing", file: bobKey + " bob\n",
+			keys: []LocalKey{localKey(t, aliceKey+" alice")}, want: []string{"alice: missing"}},
+		{name: "options stripped", file: aliceKey + " alice\n",
+			keys: []LocalKey{localKey(t, aliceKey+" alice", "restrict", `command="backup"`)}, want: []string{"alice: options-differ"}},
+		{name: "options edited", file: `restrict,command="sh" ` + aliceKey + " alice\n",
+			keys: []LocalKey{localKey(t, aliceKey+" alice", "restrict", `command="backup"`)}, want: []string{"alice: options-differ"}},
+		{name: "options added", file: `no-pty ` + aliceKey + " alice\n",
+			keys: []LocalKey{localKey(t, aliceKey+" alice")}, want: []string{"alice: options-differ"}},
+		{name: "one of two lines matches", file: aliceKey + " alice\nrestrict " + aliceKey + " alice\n",
+			keys: []LocalKey{localKey(t, aliceKey+" alice", "restrict")}, want: []string{"alice: in-sync"}},
+		{name: "managed block options differ", file: block("no-pty " + aliceKey + " alice\n"), managed: true,
+			keys: []LocalKey{localKey(t, aliceKey+" alice", "restrict")}, want: []string{"alice: options-differ"}},
+		{name: "outside the managed block", file: block(""), managed: true,
+			keys: []LocalKey{localKey(t, carolKey+" carol", "restrict")}, want: []string{"carol: in-sync"}},
+		{name: "exclusive whole file", file: aliceKey + " alice\n" + bobKey + " bob\n", exclusive: true,
+			keys: []LocalKey{localKey(t, aliceKey+" alice")}, want: []string{"alice: in-sync", "bob: unexpected"}},
+		{name: "exclusive managed block", file: block(bobKey + " bob\n"), managed: true, exclusive: true,
+			keys: []LocalKey{localKey(t, aliceKey+" alice")}, want: []string{"alice: missing", "bob: unexpected"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := checkKeys(mustDocument(t, tt.file, tt.managed), tt.keys, tt.exclusive)
+			if got := statuses(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			for _, r := range results {
+				if IsDrift(r.Status) == (r.Status == KeyInSync) {
+					t.Errorf("IsDrift(%q) = %v", r.Status, IsDrift(r.Status))
+				}
+			}
+		})
+	}
+}
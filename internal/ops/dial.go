@@ -18,10 +18,7 @@ import (
 // It will auto-add the host key to known_hosts on *first-time connection* (only when host key is missing),
 // but will NOT auto-accept mismatched host keys.
 func (k *KeyManager) dialForServer(s config.Server) (*ssh.Client, error) {
-	host, port := addrAndPort(s)
-	if port == "" {
-		port = "22"
-	}
+	host, addr := serverAddr(s)
 
 	// build auth methods
 	authMethods, _ := buildAuthMethods(s)
@@ -30,14 +27,38 @@ func (k *KeyManager) dialForServer(s config.Server) (*ssh.Client, error) {
 		return nil, fmt.Errorf("no auth methods available for host=%s user=%s", host, s.User)
 	}
 
-	// Build known_hosts callback if possible
+	// reset_knownhost: forget stale entries so a re-provisioned host is treated as first-time
+	if s.ResetsKnownHost() {
+		addrs := []string{addr}
+		if _, port, _ := net.SplitHostPort(addr); s.Host != "" && s.Host != host {
+			addrs = append(addrs, net.JoinHostPort(s.Host, port))
+		}
+		if err := k.resetKnownHost(addrs...); err != nil {
+			return nil, fmt.Errorf("reset known_hosts: %w", err)
+		}
+	}
+
+	return k.dialWithAuth(s, authMethods)
+}
+
+// serverAddr returns the host to dial and its "host:port" address (port 22 by default).
+func serverAddr(s config.Server) (host, addr string) {
+	host, port := addrAndPort(s)
+	if port == "" {
+		port = "22"
+	}
+	return host, net.JoinHostPort(host, port)
+}
+
+// dialWithAuth connects to s with exactly the given auth methods, verifying the host key
+// against known_hosts and recording it on first contact.
+func (k *KeyManager) dialWithAuth(s config.Server, authMethods []ssh.AuthMethod) (*ssh.Client, error) {
+	host, addr := serverAddr(s)
+
 	khPath := util.KnownHostsPath()
-	var knownCb ssh.HostKeyCallback
-	if cb, err := knownhosts.New(khPath); err == nil {
-		knownCb = cb
-	} else {
-		// no known_hosts file or unreadable — fall back to insecure for now (we'll capture later)
-		knownCb = ssh.InsecureIgnoreHostKey()
+	knownCb, err := k.knownHostsCallback(khPath)
+	if err != nil {
+		return nil, err
 	}
 
 	// create initial config that uses known_hosts verification
@@ -48,7 +69,6 @@ func (k *KeyManager) dialForServer(s config.Server) (*ssh.Client, error) {
 		Timeout:         k.DialTimeout,
 	}
 
-	addr := net.JoinHostPort(host, port)
 	client, err := ssh.Dial("tcp", addr, cfg)
 	if err == nil {
 		// log.Printf("[%s] auth methods: %s", addr, strings.Join(authNames, ","))   #
@@ -70,23 +90,15 @@ func (k *KeyManager) dialForServer(s config.Server) (*ssh.Client, error) {
 				return nil, fmt.Errorf("failed to capture remote host key for %s", addr)
 			}
 
-			// build known_hosts line and append to file
-			line := knownhosts.Line([]string{host}, capturedKey)
-			f, ferr := os.OpenFile(khPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
-			if ferr != nil {
-				return nil, fmt.Errorf("cannot open known_hosts (%s): %w", khPath, ferr)
+			// build known_hosts line and append to file ("[host]:port" for non-default ports)
+			line := knownhosts.Line([]string{addr}, capturedKey)
+			if ferr := k.appendKnownHost(khPath, line); ferr != nil {
+				return nil, ferr
 			}
-			if _, ferr = f.WriteString(line + "\n"); ferr != nil {
-				_ = f.Close()
-				return nil, fmt.Errorf("failed writing known_hosts: %w", ferr)
-			}
-			_ = f.Close()
 
 			// Retry original dial with verified callback
-			if cb, err := knownhosts.New(khPath); err == nil {
-				cfg.HostKeyCallback = cb
-			} else {
-				cfg.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+			if cfg.HostKeyCallback, err = k.knownHostsCallback(khPath); err != nil {
+				return nil, err
 			}
 
 			client2, err2 := ssh.Dial("tcp", addr, cfg)
@@ -105,6 +117,23 @@ func (k *KeyManager) dialForServer(s config.Server) (*ssh.Client, error) {
 	return nil, fmt.Errorf("ssh dial to %s failed: %w", addr, err)
 }
 
+// knownHostsCallback reads known_hosts under khMu, so that it never sees a half-written line
+// from a concurrent dial. Without a known_hosts file host keys are not checked; a file that
+// exists but cannot be read or parsed is an error.
+func (k *KeyManager) knownHostsCallback(khPath string) (ssh.HostKeyCallback, error) {
+	k.khMu.Lock()
+	defer k.khMu.Unlock()
+
+	cb, err := knownhosts.New(khPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read known_hosts (%s): %w", khPath, err)
+	}
+	return cb, nil
+}
+
 // fetchAndCaptureHostKey makes a temporary SSH connection that accepts the remote host key and returns that key for persistence.
 func fetchAndCaptureHostKey(addr, user string, authMethods []ssh.AuthMethod, timeout time.Duration) (ssh.PublicKey, error) {
 	var capturedKey ssh.PublicKey
@@ -125,3 +154,35 @@ func fetchAndCaptureHostKey(addr, user string, authMethods []ssh.AuthMethod, tim
 	_ = tmpClient.Close()
 	return capturedKey, nil
 }
+
+// appendKnownHost appends a single known_hosts line, holding khMu so that
+// concurrent first-time dials don't interleave their writes.
+func (k *KeyManager) appendKnownHost(khPath, line string) error {
+	k.khMu.Lock()
+	defer k.khMu.Unlock()
+
+	f, err := os.OpenFile(khPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot open known_hosts (%s): %w", khPath, err)
+	}
+	if _, err = f.WriteString(line + "\n"); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed writing known_hosts: %w", err)
+	}
+	return f.Close()
+}
+
+// resetKnownHost removes known_hosts entries for addrs, holding khMu like appendKnownHost.
+func (k *KeyManager) resetKnownHost(addrs ...string) error {
+	k.khMu.Lock()
+	defer k.khMu.Unlock()
+
+	n, err := util.ResetKnownHost(addrs...)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("[%s] removed %d known_hosts entr(ies)", addrs[0], n)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKnownHostsCallback(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	tests := []struct {
+		name string
+		path string
+		err  string
+	}{
+		{name: "missing file", path: filepath.Join(dir, "missing")},
+		{name: "valid file", path: write("valid", "[10.0.0.1]:2222 "+aliceKey+"\n")},
+		{name: "empty file", path: write("empty", "")},
+		{name: "invalid line", path: write("invalid", "10.0.0.1 ssh-ed25519 not-base64\n"), err: "read known_hosts"},
+		{name: "directory", path: dir, err: "read known_hosts"},
+	}
+	k := NewKeyManager()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, err := k.knownHostsCallback(tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || cb == nil {
+				t.Fatalf("got %v, %v", cb, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// PermIssue is one StrictModes violation found on the path to authorized_keys.
+type PermIssue struct {
+	Path    string
+	Problem string // e.g. "group/world-writable (mode 0775)"
+	Fixed   bool   // the fix was applied
+	Err     error  // the fix was attempted and failed
+}
+
+// Doctor checks the authorized_keys file and every directory above it (up to the home directory,
+// or / for files outside it) the way sshd StrictModes does: each must exist, be owned by the user
+// (below home) or by root or the user (home and above, or outside home), and not be group- or
+// world-writable; the file must be a regular file.
+// With s.FixesPermissions() set, write bits and ownership are repaired where possible.
+func (k *KeyManager) Doctor(s config.Server) ([]PermIssue, error) {
+	if s.FixesPermissions() {
+		return withLockedHost(k, s, (*Host).Doctor)
+	}
+	return withHost(k, s, (*Host).Doctor)
+}
+
+// Doctor is KeyManager.Doctor on an open connection.
+func (h *Host) Doctor() ([]PermIssue, error) {
+	akPath, err := h.keysPath()
+	if err != nil {
+		return nil, err
+	}
+	defer h.phase("doctor")()
+	fix := h.s.FixesPermissions()
+	issues, err := h.k.checkPermissions(h.fs, akPath, fix)
+	if fix {
+		// the shell transport queues fixes; run them now so each issue reports its real outcome
+		if ferr := h.fs.Flush(); ferr != nil {
+			for i := range issues {
+				if issues[i].Fixed {
+					issues[i].Fixed, issues[i].Err = false, ferr
+				}
+			}
+		}
+	}
+	return issues, err
+}
+
+// checkPermissions runs the StrictModes checks for akPath over fs, fixing violations if fix is set.
+func (k *KeyManager) checkPermissions(fs remoteFS, akPath string, fix bool) ([]PermIssue, error) {
+	home, err := fs.Home()
+	if err != nil {
+		return nil, fmt.Errorf("remote home: %w", err)
+	}
+	uid, err := fs.UID()
+	if err != nil {
+		return nil, fmt.Errorf("remote uid: %w", err)
+	}
+
+	target := akPath
+	if target == "~" || strings.HasPrefix(target, "~/") {
+		target = path.Join(home, strings.TrimPrefix(target, "~"))
+	}
+
+	var issues []PermIssue
+	for i, p := range strictModesPaths(target, home) {
+		st, err := fs.Stat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			issues = append(issues, PermIssue{Path: p, Problem: "does not exist"})
+			continue
+		}
+		if errors.Is(err, os.ErrPermission) {
+			// e.g. inside a root-owned 0700 ~/.ssh; the directory itself is reported below
+			issues = append(issues, PermIssue{Path: p, Problem: "not accessible to the user"})
+			continue
+		}
+		if err != nil {
+			return issues, fmt.Errorf("stat %s: %w", p, err)
+		}
+
+		if i == 0 && st.Mode.IsDir() {
+			issues = append(issues, PermIssue{Path: p, Problem: "not a regular file"})
+			continue
+		}
+		// sshd reads ~/.ssh and authorized_keys as the user, so below home only the user will do;
+		// home and the directories above it (or a file outside home) may also belong to root
+		userOnly := insideHome(p, home)
+		if st.UID != uid && (userOnly || st.UID != 0) {
+			want := "root"
+			switch {
+			case userOnly:
+				want = fmt.Sprintf("%d", uid)
+			case uid != 0:
+				want = fmt.Sprintf("%d or root", uid)
+			}
+			is := PermIssue{Path: p, Problem: fmt.Sprintf("owned by uid %d, want %s", st.UID, want)}
+			if fix {
+				is.Err = fs.Chown(p, uid, st.GID)
+				is.Fixed = is.Err == nil
+			}
+			issues = append(issues, is)
+		}
+		if st.Mode.Perm()&0o022 != 0 {
+			is := PermIssue{Path: p, Problem: fmt.Sprintf("group/world-writable (mode %04o)", st.Mode.Perm())}
+			if fix {
+				is.Err = fs.Chmod(p, st.Mode.Perm()&^0o022)
+				is.Fixed = is.Err == nil
+			}
+			issues = append(issues, is)
+		}
+	}
+	return issues, nil
+}
+
+// insideHome reports whether p lies below home (home itself is not inside).
+func insideHome(p, home string) bool {
+	p, home = path.Clean(p), path.Clean(home)
+	return p != home && strings.HasPrefix(p, strings.TrimSuffix(home, "/")+"/")
+}
+
+// strictModesPaths lists target followed by each parent directory sshd checks:
+// up to and including home when target is inside it, otherwise up to /.
+func strictModesPaths(target, home string) []string {
+	target, home = path.Clean(target), path.Clean(home)
+	paths := []string{target}
+	inHome := insideHome(target, home)
+	for dir := path.Dir(target); ; dir = path.Dir(dir) {
+		paths = append(paths, dir)
+		if (inHome && dir == home) || dir == "/" {
+			return paths
+		}
+	}
+}
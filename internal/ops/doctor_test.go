@@ -0,0 +1,223 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestInsideHome(t *testing.T) {
+	tests := []struct {
+		p, home string
+		want    bool
+	}{
+		{"/home/u/.ssh/authorized_keys", "/home/u", true},
+		{"/home/u/.ssh", "/home/u/", true},
+		{"/home/u/./.ssh/../.ssh", "/home/u", true},
+		{"/home/u", "/home/u", false},
+		{"/home/u/", "/home/u", false},
+		{"/home", "/home/u", false},
+		{"/home/user2/.ssh", "/home/u", false},
+		{"/etc/ssh/keys/u", "/home/u", false},
+		{"/root/.ssh", "/", true},
+		{"/", "/", false},
+	}
+	for _, tt := range tests {
+		if got := insideHome(tt.p, tt.home); got != tt.want {
+			t.Errorf("insideHome(%q, %q) = %v, want %v", tt.p, tt.home, got, tt.want)
+		}
+	}
+}
+
+func TestStrictModesPaths(t *testing.T) {
+	tests := []struct {
+		target, home string
+		want         []string
+	}{
+		{"/home/u/.ssh/authorized_keys", "/home/u", []string{"/home/u/.ssh/authorized_keys", "/home/u/.ssh", "/home/u"}},
+		{"/home/u/.ssh/authorized_keys", "/home/u/", []string{"/home/u/.ssh/authorized_keys", "/home/u/.ssh", "/home/u"}},
+		{"/home/u/keys", "/home/u", []string{"/home/u/keys", "/home/u"}},
+		{"/etc/ssh/keys/u", "/home/u", []string{"/etc/ssh/keys/u", "/etc/ssh/keys", "/etc/ssh", "/etc", "/"}},
+		{"/root/.ssh/authorized_keys", "/", []string{"/root/.ssh/authorized_keys", "/root/.ssh", "/root", "/"}},
+	}
+	for _, tt := range tests {
+		if got := strictModesPaths(tt.target, tt.home); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("strictModesPaths(%q, %q) = %q, want %q", tt.target, tt.home, got, tt.want)
+		}
+	}
+}
+
+// statFS is a remoteFS with fixed metadata for the doctor checks; it records every fix.
+type statFS struct {
+	remoteFS
+	home  string
+	uid   int
+	stats map[string]remoteStat
+	errs  map[string]error
+	fixes []string
+}
+
+func (f *statFS) Home() (string, error) { return f.home, nil }
+func (f *statFS) UID() (int, error)     { return f.uid, nil }
+
+func (f *statFS) Stat(p string) (remoteStat, error) {
+	if err := f.errs[p]; err != nil {
+		return remoteStat{}, err
+	}
+	st, ok := f.stats[p]
+	if !ok {
+		return remoteStat{}, fmt.Errorf("stat %s: %w", p, os.ErrNotExist)
+	}
+	return st, nil
+}
+
+func (f *statFS) Chmod(p string, mode os.FileMode) error {
+	f.fixes = append(f.fixes, fmt.Sprintf("chmod %04o %s", mode, p))
+	return nil
+}
+
+func (f *statFS) Chown(p string, uid, gid int) error {
+	f.fixes = append(f.fixes, fmt.Sprintf("chown %d:%d %s", uid, gid, p))
+	return nil
+}
+
+func TestCheckPermissions(t *testing.T) {
+	const (
+		home = "/home/u"
+		ssh  = "/home/u/.ssh"
+		ak   = "/home/u/.ssh/authorized_keys"
+	)
+	dir := func(perm os.FileMode, uid int) remoteStat {
+		return remoteStat{Mode: os.ModeDir | perm, UID: uid, GID: uid}
+	}
+	file := func(perm os.FileMode, uid int) remoteStat { return remoteStat{Mode: perm, UID: uid, GID: uid} }
+	tests := []struct {
+		name   string
+		root   bool // check as root instead of uid 1000
+		akPath string
+		stats  map[string]remoteStat // overrides a correct ~/.ssh/authorized_keys tree owned by 1000
+		errs   map[string]error
+		fix    bool
+		want   []string
+		fixes  []string
+	}{
+		{name: "all correct"},
+		{name: "tilde path", akPath: "~/.ssh/authorized_keys"},
+		{name: "root may own home and its parents", stats: map[string]remoteStat{home: dir(0o755, 0)}},
+		{
+			name:  "root may not own ~/.ssh",
+			stats: map[string]remoteStat{ssh: dir(0o700, 0)},
+			want:  []string{ssh + ": owned by uid 0, want 1000"},
+		},
+		{
+			name:  "root may not own authorized_keys",
+			stats: map[string]remoteStat{ak: file(0o600, 0)},
+			fix:   true,
+			want:  []string{ak + ": owned by uid 0, want 1000 (fixed)"},
+			fixes: []string{"chown 1000:0 " + ak},
+		},
+		{
+			name:  "home owned by another user",
+			stats: map[string]remoteStat{home: dir(0o755, 2000)},
+			want:  []string{home + ": owned by uid 2000, want 1000 or root"},
+		},
+		{
+			name:  "root user",
+			root:  true,
+			stats: map[string]remoteStat{home: dir(0o700, 0), ssh: dir(0o700, 5), ak: file(0o600, 0)},
+			want:  []string{ssh + ": owned by uid 5, want 0"},
+		},
+		{
+			name:  "group-writable ~/.ssh",
+			stats: map[string]remoteStat{ssh: dir(0o770, 1000)},
+			fix:   true,
+			want:  []string{ssh + ": group/world-writable (mode 0770) (fixed)"},
+			fixes: []string{"chmod 0750 " + ssh},
+		},
+		{
+			name:  "world-writable home",
+			stats: map[string]remoteStat{home: dir(0o777, 1000)},
+			want:  []string{home + ": group/world-writable (mode 0777)"},
+		},
+		{
+			name:  "world-writable authorized_keys owned by root",
+			stats: map[string]remoteStat{ak: file(0o666, 0)},
+			want:  []string{ak + ": owned by uid 0, want 1000", ak + ": group/world-writable (mode 0666)"},
+		},
+		{name: "group-readable is fine", stats: map[string]remoteStat{ssh: dir(0o755, 1000), ak: file(0o644, 1000)}},
+		{
+			name:  "missing authorized_keys",
+			stats: map[string]remoteStat{ak: {}},
+			want:  []string{ak + ": does not exist"},
+		},
+		{
+			name:  "authorized_keys is a directory",
+			stats: map[string]remoteStat{ak: dir(0o700, 1000)},
+			want:  []string{ak + ": not a regular file"},
+		},
+		{
+			name:  "unreadable ~/.ssh",
+			errs:  map[string]error{ak: os.ErrPermission},
+			stats: map[string]remoteStat{ssh: dir(0o700, 0)},
+			want:  []string{ak + ": not accessible to the user", ssh + ": owned by uid 0, want 1000"},
+		},
+		{
+			name:   "outside home, root-owned",
+			akPath: "/etc/ssh/keys/u",
+			stats: map[string]remoteStat{
+				"/etc/ssh/keys/u": file(0o644, 0), "/etc/ssh/keys": dir(0o755, 0),
+				"/etc/ssh": dir(0o755, 0), "/etc": dir(0o755, 0), "/": dir(0o755, 0),
+			},
+		},
+		{
+			name:   "outside home, writable parent",
+			akPath: "/etc/ssh/keys/u",
+			stats: map[string]remoteStat{
+				"/etc/ssh/keys/u": file(0o644, 1000), "/etc/ssh/keys": dir(0o1777, 0),
+				"/etc/ssh": dir(0o755, 0), "/etc": dir(0o755, 0), "/": dir(0o755, 2),
+			},
+			want: []string{"/etc/ssh/keys: group/world-writable (mode 0777)", "/: owned by uid 2, want 1000 or root"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid := 1000
+			if tt.root {
+				uid = 0
+			}
+			fs := &statFS{home: home, uid: uid, errs: tt.errs, stats: map[string]remoteStat{
+				home: dir(0o755, 1000), ssh: dir(0o700, 1000), ak: file(0o600, 1000),
+			}}
+			for p, st := range tt.stats {
+				if st == (remoteStat{}) {
+					delete(fs.stats, p)
+					continue
+				}
+				fs.stats[p] = st
+			}
+			akPath := tt.akPath
+			if akPath == "" {
+				akPath = ak
+			}
+			issues, err := NewKeyManager().checkPermissions(fs, akPath, tt.fix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, is := range issues {
+				s := is.Path + ": " + is.Problem
+				if is.Fixed {
+					s += " (fixed)"
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("issues = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(fs.fixes, tt.fixes) {
+				t.Errorf("fixes = %q, want %q", fs.fixes, tt.fixes)
+			}
+		})
+	}
+}
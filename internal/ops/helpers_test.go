@@ -0,0 +1,114 @@
+package ops
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+const (
+	aliceKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMnJHrlG6MZoTr+wScNAKnDaPBdTDg49vboB4yU+Pik6"
+	bobKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAID0mRiWHT32pSuNOXtlxaRWhDkb4+4a3sbyPxIBWs0r1"
+	carolKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILguRkP7i0nvPkWclgS6TENaIFjK2PsLqx64HxRaf0Nr"
+	daveKey  = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOrjyjp1yn/6meO8Npwj1sXUVt/h7HZMenDen93SWiXJ"
+)
+
+// testAuthorizedKeys is where the Hosts of testHost keep authorized_keys.
+const testAuthorizedKeys = "/home/u/.ssh/authorized_keys"
+
+// mapFS is an in-memory remoteFS keyed by absolute path. Methods the tests do not need panic.
+type mapFS struct {
+	remoteFS
+	files map[string]string
+}
+
+func (m mapFS) ReadFile(p string) ([]byte, error) {
+	data, ok := m.files[p]
+	if !ok {
+		return nil, nil
+	}
+	return []byte(data), nil
+}
+
+func (m mapFS) WriteFile(p string, data []byte, _ os.FileMode) error {
+	m.files[p] = string(data)
+	return nil
+}
+
+func (m mapFS) Copy(src, dst string) error {
+	if data, ok := m.files[src]; ok {
+		m.files[dst] = data
+	}
+	return nil
+}
+
+func (m mapFS) ReadDir(dir string) ([]string, error) {
+	dir = path.Clean(dir)
+	var names []string
+	for p := range m.files {
+		if path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	return names, nil
+}
+
+func (m mapFS) Remove(p string) error {
+	delete(m.files, p)
+	return nil
+}
+
+func (m mapFS) MkdirAll(string, os.FileMode) error { return nil }
+func (m mapFS) Chmod(string, os.FileMode) error    { return nil }
+func (m mapFS) Flush() error                       { return nil }
+
+// backups returns the paths of the authorized_keys backups in m.
+func (m mapFS) backups() []string {
+	var out []string
+	for p := range m.files {
+		if strings.HasPrefix(p, testAuthorizedKeys+".bak.") {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// testHost returns a Host for s whose authorized_keys, at testAuthorizedKeys, holds data.
+func testHost(s config.Server, data string) (*Host, mapFS) {
+	fs := mapFS{files: map[string]string{testAuthorizedKeys: data}}
+	return &Host{k: NewKeyManager(), s: s, fs: fs, akPath: testAuthorizedKeys}, fs
+}
+
+// localKey returns the configured key line (a key and its comment) rendered with options.
+func localKey(t *testing.T, line string, options ...string) LocalKey {
+	t.Helper()
+	e, err := authkeys.ParseLine(line)
+	if err != nil || !e.IsKey() {
+		t.Fatalf("ParseLine(%q) = %v", line, err)
+	}
+	return LocalKey{Source: e.Comment, Key: e, Line: e.Render(options)}
+}
+
+func mustDocument(t *testing.T, data string, managed bool) *authkeys.Document {
+	t.Helper()
+	doc, err := authkeys.ParseDocument([]byte(data), managed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// statuses returns the Source: Status pairs of results.
+func statuses(results []KeyResult) []string {
+	var out []string
+	for _, r := range results {
+		out = append(out, r.Source+": "+r.Status)
+	}
+	return out
+}
+
+func boolPtr(b bool) *bool { return &b }
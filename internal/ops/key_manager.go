@@ -1,12 +1,34 @@
 package ops
 
-import "time"
+import (
+	"net/http"
+	"sync"
+	"time"
 
-// KeyManager manages SSH key operations over SSH (native Go)
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// KeyManager manages SSH key operations over SSH (native Go).
+// A single KeyManager is safe to share between goroutines.
 type KeyManager struct {
 	DialTimeout time.Duration
+
+	BackupKeep   int           // keep at most this many authorized_keys backups (0 = unlimited)
+	BackupMaxAge time.Duration // delete backups older than this (0 = never)
+	LockTimeout  time.Duration // wait this long for another run's remote lock
+
+	URLCacheMaxAge time.Duration // use a cached url: key list up to this old when the endpoint fails (0 = never)
+
+	khMu         sync.Mutex // serializes known_hosts updates from concurrent dials
+	urlMu        sync.Mutex
+	urlKeys      map[string]*urlKeys // url: key lists, fetched once per run
+	urlTransport http.RoundTripper   // nil = http.DefaultTransport
 }
 
 func NewKeyManager() *KeyManager {
-	return &KeyManager{DialTimeout: 15 * time.Second}
+	return &KeyManager{
+		DialTimeout:    15 * time.Second,
+		LockTimeout:    config.DefaultLockTimeout,
+		URLCacheMaxAge: config.DefaultURLCacheMaxAge,
+	}
 }
@@ -2,130 +2,293 @@ package ops
 
 import (
 	"fmt"
-	"os"
-	"os/user"
-	"path/filepath"
+	"path"
 	"strings"
 
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
 	"github.com/thineshsubramani/sync-ssh-id/internal/config"
-	"github.com/thineshsubramani/sync-ssh-id/internal/util"
 )
 
-// Inject appends public key to remote authorized_keys idempotently
-func (k *KeyManager) Inject(s config.Server) error {
-	pubPath := util.ExpandPath(s.PublicKey)
-	if pubPath == "" {
-		usr, _ := user.Current()
-		pubPath = filepath.Join(usr.HomeDir, ".ssh", "id_rsa.pub")
+const defaultAuthorizedKeys = "~/.ssh/authorized_keys"
+
+// keyEdit applies one key to the parsed authorized_keys document and returns the key's status
+// and the fingerprints of any other keys it replaced. keys is every configured key, so an edit
+// never replaces one of them. Only doc.Block may be modified.
+type keyEdit func(doc *authkeys.Document, key LocalKey, keys []LocalKey) (status string, replaced []string)
+
+// applyKeys resolves every key of the server, then reads authorized_keys once,
+// applies edit to each key and, only if anything changed, backs the file up and writes it back once.
+// When the server uses a managed block, edits are confined to it.
+// The returned error is for host-level failures (dial, read, write); per-key outcomes are in the results.
+//
+// With verify set, each configured key that ends up in the file is checked with a fresh login (see verifyKeys).
+//
+// With exclusive set, every other key in the file is pruned afterwards; this is refused if any
+// configured key could not be resolved (including a url: source that failed or served no keys),
+// so a typo or an endpoint outage never removes the key it was meant to keep.
+func (h *Host) applyKeys(ensureDir, exclusive, verify bool, edit keyEdit) ([]KeyResult, error) {
+	s, k, fs := h.s, h.k, h.fs
+	keys, results := k.resolveKeys(s)
+	if len(keys) == 0 {
+		return results, fmt.Errorf("no usable public keys")
 	}
-	if _, err := os.Stat(pubPath); err != nil {
-		return fmt.Errorf("public key not found: %s", pubPath)
+	if exclusive && len(results) > 0 {
+		return results, fmt.Errorf("exclusive mode: refusing to prune while %d key source(s) failed", len(results))
 	}
 
-	keyData, err := os.ReadFile(pubPath)
+	akPath, err := h.keysPath()
 	if err != nil {
-		return fmt.Errorf("read pubkey: %w", err)
+		return results, err
 	}
-	pubLine := strings.TrimSpace(string(keyData))
 
-	client, err := k.dialForServer(s)
-	if err != nil {
-		return fmt.Errorf("dial: %w", err)
+	if ensureDir {
+		if err := k.ensureKeyDir(fs, akPath); err != nil {
+			return results, fmt.Errorf("ensure ssh dir: %w", err)
+		}
 	}
-	defer client.Close()
 
-	if err := k.ensureSSHDir(client); err != nil {
-		return fmt.Errorf("ensure ssh dir: %w", err)
+	done := h.phase("read")
+	doc, err := k.remoteReadAuthorizedKeys(fs, akPath, s.UsesManagedBlock())
+	done()
+	if err != nil {
+		return results, fmt.Errorf("read authorized_keys: %w", err)
 	}
 
-	if err := k.backupAuthorizedKeys(client); err != nil {
-		return fmt.Errorf("backup authorized_keys: %w", err)
+	changed := false
+	for _, key := range keys {
+		status, replaced := edit(doc, key, keys)
+		if isChange(status) {
+			changed = true
+		}
+		results = append(results, KeyResult{Source: key.Source, Fingerprint: key.Key.Fingerprint(), Status: status, Replaced: replaced})
 	}
-
-	exists, err := k.remoteHasExactLine(client, pubLine)
-	if err != nil {
-		return fmt.Errorf("check existing key: %w", err)
+	if exclusive {
+		pruned := pruneUnlisted(doc.Block, keys)
+		if len(pruned) > 0 {
+			changed = true
+		}
+		results = append(results, pruned...)
 	}
-	if exists {
-		return nil
+	if changed {
+		if s.BacksUpAuthorizedKeys() {
+			done := h.phase("backup")
+			err := k.backupAuthorizedKeys(fs, akPath)
+			done()
+			if err != nil {
+				err = fmt.Errorf("backup authorized_keys: %w", err)
+				return markFailed(results, err), err
+			}
+		}
+		done := h.phase("write")
+		err := k.remoteWriteAuthorizedKeys(fs, akPath, doc.Bytes())
+		done()
+		if err != nil {
+			return markFailed(results, err), err
+		}
+	}
+	if verify {
+		done := h.phase("verify")
+		results = k.verifyKeys(s, keys, results)
+		done()
 	}
+	return results, nil
+}
 
-	if err := k.remoteAppendLine(client, pubLine); err != nil {
-		return fmt.Errorf("append pubkey: %w", err)
+// isChange reports whether a key status means authorized_keys was modified.
+func isChange(status string) bool {
+	return status == KeyAdded || status == KeyUpdated || status == KeyRemoved || status == KeyPruned || status == KeyReplaced || status == KeyExpired
+}
+
+// pruneUnlisted removes every key entry of f that is not one of keys and reports each removal.
+// Comments and blank lines are kept. In managed mode f is only the managed block.
+func pruneUnlisted(f *authkeys.File, keys []LocalKey) []KeyResult {
+	var out []KeyResult
+	for _, e := range f.Keys() {
+		if isConfigured(e, keys) {
+			continue
+		}
+		f.RemoveLine(e.Line)
+		src := e.Comment
+		if src == "" {
+			src = fmt.Sprintf("line %d", e.Line)
+		}
+		out = append(out, KeyResult{Source: src, Fingerprint: e.Fingerprint(), Status: KeyPruned})
 	}
+	return out
+}
 
-	if err := k.remoteChmod(client, "~/.ssh/authorized_keys", "600"); err != nil {
-		return fmt.Errorf("set perms: %w", err)
+// markFailed turns the pending changes in results into failures after a write error.
+func markFailed(results []KeyResult, err error) []KeyResult {
+	for i, r := range results {
+		if isChange(r.Status) {
+			results[i].Status = KeyFailed
+			results[i].Err = err
+		}
 	}
+	return results
+}
 
-	return nil
+// Inject appends every configured public key (with its options) to remote authorized_keys idempotently.
+// Presence is decided by key blob, so the same key with another comment or options is not added twice.
+// In exclusive mode all other keys are removed.
+func (k *KeyManager) Inject(s config.Server) ([]KeyResult, error) {
+	return withLockedHost(k, s, (*Host).Inject)
+}
+
+// Inject is KeyManager.Inject on an open connection.
+func (h *Host) Inject() ([]KeyResult, error) {
+	return h.applyKeys(true, h.s.IsExclusive(), h.s.Verifies(), func(doc *authkeys.Document, key LocalKey, _ []LocalKey) (string, []string) {
+		if doc.Contains(key.Key) {
+			return KeyPresent, nil
+		}
+		doc.Block.Append(key.Line)
+		return KeyAdded, nil
+	})
 }
 
-// InjectWithCustomPath injects given pubKey into custom remotePath
+// InjectWithCustomPath injects given pubKey into custom remotePath ("auto" asks sshd)
 func (k *KeyManager) InjectWithCustomPath(s config.Server, pubKey string, remotePath string) error {
+	s.RemotePath = remotePath // lock next to the file being changed
+	_, err := withLockedHost(k, s, func(h *Host) (struct{}, error) {
+		return struct{}{}, h.InjectWithCustomPath(pubKey, remotePath)
+	})
+	return err
+}
+
+// InjectWithCustomPath is KeyManager.InjectWithCustomPath on an open connection.
+func (h *Host) InjectWithCustomPath(pubKey string, remotePath string) error {
+	k, fs := h.k, h.fs
 	pub := strings.TrimSpace(pubKey)
 	if pub == "" {
 		return fmt.Errorf("public key content is empty")
 	}
-	if strings.TrimSpace(remotePath) == "" {
-		remotePath = "~/.ssh/authorized_keys"
+	key, err := authkeys.ParsePublicKey([]byte(pub))
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	if strings.TrimSpace(remotePath) == "" || remotePath == h.s.RemotePath {
+		remotePath, err = h.keysPath()
+	} else {
+		remotePath, err = k.authorizedKeysPath(h.client, fs, h.s, remotePath)
 	}
-
-	client, err := k.dialForServer(s)
 	if err != nil {
-		return fmt.Errorf("connect: %w", err)
+		return err
 	}
-	defer client.Close()
 
-	escaped := escapeForSingleQuotes(pub)
-
-	cmd := fmt.Sprintf(
-		"mkdir -p $(dirname %s) && touch %s && grep -F -x %s %s >/dev/null 2>&1 || printf '%%s\\n' %s >> %s && chmod 600 %s",
-		remotePath, remotePath, escaped, remotePath, escaped, remotePath, remotePath,
-	)
+	if err := fs.MkdirAll(path.Dir(remotePath), 0o700); err != nil {
+		return fmt.Errorf("create %s: %w", path.Dir(remotePath), err)
+	}
 
-	out, err := runRemote(client, cmd)
+	doc, err := k.remoteReadAuthorizedKeys(fs, remotePath, false)
 	if err != nil {
-		return fmt.Errorf("remote cmd failed: %v, output: %s", err, out)
+		return fmt.Errorf("check existing key: %w", err)
+	}
+	if doc.Contains(key) {
+		return nil
 	}
 
+	doc.Block.Append(key.Render(key.Options))
+	if err := k.remoteWriteAuthorizedKeys(fs, remotePath, doc.Bytes()); err != nil {
+		return fmt.Errorf("append pubkey: %w", err)
+	}
 	return nil
 }
 
-// Delete removes the public key from remote authorized_keys
-func (k *KeyManager) Delete(s config.Server) error {
-	pubPath := util.ExpandPath(s.PublicKey)
-	if pubPath == "" {
-		usr, _ := user.Current()
-		pubPath = filepath.Join(usr.HomeDir, ".ssh", "id_rsa.pub")
-	}
-	if _, err := os.Stat(pubPath); err != nil {
-		return fmt.Errorf("public key not found: %s", pubPath)
-	}
-	keyData, err := os.ReadFile(pubPath)
-	if err != nil {
-		return fmt.Errorf("read pubkey: %w", err)
-	}
-	pubLine := strings.TrimSpace(string(keyData))
+// Delete removes every line carrying one of the configured public keys from remote authorized_keys,
+// whatever its comment or options.
+func (k *KeyManager) Delete(s config.Server) ([]KeyResult, error) {
+	return withLockedHost(k, s, (*Host).Delete)
+}
 
-	client, err := k.dialForServer(s)
-	if err != nil {
-		return fmt.Errorf("dial: %w", err)
+// Delete is KeyManager.Delete on an open connection.
+func (h *Host) Delete() ([]KeyResult, error) {
+	return h.applyKeys(false, false, false, func(doc *authkeys.Document, key LocalKey, _ []LocalKey) (string, []string) {
+		if doc.Block.Remove(key.Key) > 0 {
+			return KeyRemoved, nil
+		}
+		if doc.Outside().Contains(key.Key) {
+			return KeyUnmanaged, nil
+		}
+		return KeyAbsent, nil
+	})
+}
+
+// Update makes the remote line for each public key match the configuration: a key that is
+// already present (e.g. with different options) is rewritten in place, a missing key is appended.
+// With an identity configured, a missing key instead takes the place of the older keys with the
+// same comment or key_id (e.g. the same user's key from a previous laptop).
+// In exclusive mode all other keys are removed.
+func (k *KeyManager) Update(s config.Server) ([]KeyResult, error) {
+	return withLockedHost(k, s, (*Host).Update)
+}
+
+// Update is KeyManager.Update on an open connection.
+func (h *Host) Update() ([]KeyResult, error) {
+	identity := strings.ToLower(strings.TrimSpace(h.s.Identity))
+	switch identity {
+	case "", IdentityComment, IdentityKeyID:
+	default:
+		return nil, fmt.Errorf("unknown identity %q (want comment or key_id)", h.s.Identity)
 	}
-	defer client.Close()
+	return h.applyKeys(true, h.s.IsExclusive(), h.s.Verifies(), func(doc *authkeys.Document, key LocalKey, keys []LocalKey) (string, []string) {
+		if doc.Block.Contains(key.Key) {
+			if doc.Block.Replace(key.Key, key.Line) {
+				return KeyUpdated, nil
+			}
+			return KeyUnchanged, nil
+		}
+		if doc.Outside().Contains(key.Key) {
+			return KeyUnmanaged, nil
+		}
+		if old := sameIdentity(doc.Block, key, keys, identity); len(old) > 0 {
+			// the first older key's line becomes the new key, keeping its position
+			doc.Block.Replace(old[0], key.Line)
+			replaced := []string{old[0].Fingerprint()}
+			for _, e := range old[1:] {
+				doc.Block.Remove(e)
+				replaced = append(replaced, e.Fingerprint())
+			}
+			return KeyReplaced, replaced
+		}
+		doc.Block.Append(key.Line)
+		return KeyAdded, nil
+	})
+}
 
-	if err := k.backupAuthorizedKeys(client); err != nil {
-		return fmt.Errorf("backup authorized_keys: %w", err)
+// sameIdentity returns the entries of f that share key's identity (comment or key_id) but are
+// none of the configured keys. An empty identity value never matches.
+func sameIdentity(f *authkeys.File, key LocalKey, keys []LocalKey, identity string) []authkeys.Entry {
+	id := identityOf(key.Key, identity)
+	if id == "" {
+		return nil
+	}
+	var out []authkeys.Entry
+	for _, e := range f.Keys() {
+		if identityOf(e, identity) != id || isConfigured(e, keys) {
+			continue
+		}
+		out = append(out, e)
 	}
+	return out
+}
 
-	if err := k.remoteRemoveExactLine(client, pubLine); err != nil {
-		return fmt.Errorf("remove pubkey: %w", err)
+// identityOf returns the value update matches keys by.
+func identityOf(e authkeys.Entry, identity string) string {
+	switch identity {
+	case IdentityComment:
+		return e.BaseComment()
+	case IdentityKeyID:
+		return e.KeyID()
 	}
-	return nil
+	return ""
 }
 
-// Update removes then reinjects the public key
-func (k *KeyManager) Update(s config.Server) error {
-	_ = k.Delete(s)
-	return k.Inject(s)
+// isConfigured reports whether e carries one of keys.
+func isConfigured(e authkeys.Entry, keys []LocalKey) bool {
+	for _, key := range keys {
+		if e.SameKey(key.Key) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,242 @@
+package ops
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// inlineKeys returns public_keys entries for the given inline key lines.
+func inlineKeys(lines ...string) []config.KeySpec {
+	var specs []config.KeySpec
+	for _, l := range lines {
+		specs = append(specs, config.KeySpec{Key: l})
+	}
+	return specs
+}
+
+func TestApplyKeysBacksUpOnlyOnChange(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		backup      *bool
+		want        []string
+		wantBackups int
+	}{
+		{name: "already present", file: aliceKey + " alice\n", want: []string{"inline: present"}},
+		{name: "added", file: bobKey + " bob\n", want: []string{"inline: added"}, wantBackups: 1},
+		{name: "added, backups off", file: bobKey + " bob\n", backup: boolPtr(false), want: []string{"inline: added"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := config.Server{PublicKeys: inlineKeys(aliceKey + " alice"), BackupAuthorizedKeys: tt.backup}
+			h, fs := testHost(s, tt.file)
+			results, err := h.Inject()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := statuses(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if n := len(fs.backups()); n != tt.wantBackups {
+				t.Errorf("%d backups written, want %d", n, tt.wantBackups)
+			}
+			for _, b := range fs.backups() {
+				if fs.files[b] != tt.file {
+					t.Errorf("backup %s = %q, want the file before the change %q", b, fs.files[b], tt.file)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneUnlisted(t *testing.T) {
+	const (
+		before = "# by hand\r\n" + carolKey + " carol@laptop\r\n\n"
+		after  = bobKey + " cloud-init\n# trailing, no newline"
+	)
+	block := func(lines string) string {
+		return before + authkeys.BeginMarker + "\n" + lines + authkeys.EndMarker + "\n" + after
+	}
+	alice := localKey(t, aliceKey+" alice", "restrict")
+	tests := []struct {
+		name    string
+		file    string
+		managed bool
+		keys    []LocalKey
+		want    string
+		pruned  []string
+	}{
+		{
+			name: "whole file", file: "# team\n" + aliceKey + " alice\n" + bobKey + " bob\n\n" + carolKey + " carol\n",
+			keys: []LocalKey{alice}, want: "# team\n" + aliceKey + " alice\n\n", pruned: []string{"bob: pruned", "carol: pruned"},
+		},
+		{
+			name: "whole file, nothing to prune", file: aliceKey + " alice\n",
+			keys: []LocalKey{alice}, want: aliceKey + " alice\n",
+		},
+		{
+			name: "managed block leaves the rest byte-for-byte", managed: true,
+			file: block(aliceKey + " alice\n" + carolKey + " carol@block\n"),
+			keys: []LocalKey{alice}, want: block(aliceKey + " alice\n"), pruned: []string{"carol@block: pruned"},
+		},
+		{
+			name: "managed block emptied", managed: true, file: block(bobKey + " bob\n"),
+			keys: []LocalKey{alice}, want: block(""), pruned: []string{"bob: pruned"},
+		},
+		{
+			name: "configured key on two lines with different options is kept", file: aliceKey + " alice\nno-pty " + aliceKey + " alice@2\n" + bobKey + "\n",
+			keys: []LocalKey{alice}, want: aliceKey + " alice\nno-pty " + aliceKey + " alice@2\n", pruned: []string{"line 3: pruned"},
+		},
+		{
+			name: "unlisted key on two lines", file: bobKey + " bob\n" + aliceKey + " alice\nrestrict " + bobKey + " bob@2\n",
+			keys: []LocalKey{alice}, want: aliceKey + " alice\n", pruned: []string{"bob: pruned", "bob@2: pruned"},
+		},
+		{
+			name: "same key configured twice", file: aliceKey + " alice\n" + bobKey + " bob\n",
+			keys: []LocalKey{alice, localKey(t, aliceKey+" alice@other")}, want: aliceKey + " alice\n", pruned: []string{"bob: pruned"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := mustDocument(t, tt.file, tt.managed)
+			got := statuses(pruneUnlisted(doc.Block, tt.keys))
+			if !reflect.DeepEqual(got, tt.pruned) {
+				t.Errorf("pruned %q, want %q", got, tt.pruned)
+			}
+			if out := string(doc.Bytes()); out != tt.want {
+				t.Errorf("file =\n%q\nwant\n%q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyKeysExclusive(t *testing.T) {
+	const file = aliceKey + " alice\n" + bobKey + " bob\n"
+	tests := []struct {
+		name    string
+		keys    []config.KeySpec
+		want    []string
+		wantErr string
+		file    string
+	}{
+		{
+			name: "prunes unlisted keys", keys: inlineKeys(aliceKey + " alice"),
+			want: []string{"inline: present", "bob: pruned"}, file: aliceKey + " alice\n",
+		},
+		{
+			name: "same key listed twice", keys: inlineKeys(aliceKey+" alice", aliceKey+" alice@again"),
+			want: []string{"inline: present", "bob: pruned"}, file: aliceKey + " alice\n",
+		},
+		{
+			name: "refuses while a source failed", keys: append(inlineKeys(aliceKey+" alice"), config.KeySpec{Key: "/nonexistent/id.pub"}),
+			want: []string{"/nonexistent/id.pub: failed"}, wantErr: "refusing to prune while 1 key source(s) failed", file: file,
+		},
+		{
+			name: "refuses while an option is invalid", keys: []config.KeySpec{{Key: aliceKey, Options: &config.KeyOptions{Command: `echo \`}}, {Key: bobKey}},
+			want: []string{"inline: failed"}, wantErr: "refusing to prune", file: file,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, fs := testHost(config.Server{PublicKeys: tt.keys, Exclusive: boolPtr(true)}, file)
+			results, err := h.Inject()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if got := statuses(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if fs.files[testAuthorizedKeys] != tt.file {
+				t.Errorf("file = %q, want %q", fs.files[testAuthorizedKeys], tt.file)
+			}
+		})
+	}
+}
+
+func TestUpdateIdentity(t *testing.T) {
+	fp := func(line string) string { return localKey(t, line).Key.Fingerprint() }
+	tests := []struct {
+		name     string
+		identity string
+		keys     []config.KeySpec
+		file     string
+		want     string
+		statuses []string
+		replaced []string
+	}{
+		{
+			name: "by comment, in place", identity: IdentityComment, keys: inlineKeys(daveKey + " alice@corp"),
+			file:     "# team\n" + aliceKey + " alice@corp\n" + bobKey + " bob@corp\n",
+			want:     "# team\n" + daveKey + " alice@corp\n" + bobKey + " bob@corp\n",
+			statuses: []string{"inline: replaced"}, replaced: []string{fp(aliceKey)},
+		},
+		{
+			name: "by comment, annotations ignored", identity: IdentityComment, keys: inlineKeys(daveKey + " alice@corp"),
+			file:     `no-pty ` + aliceKey + " alice@corp key_id=old expires=2020-01-01\n",
+			want:     daveKey + " alice@corp\n",
+			statuses: []string{"inline: replaced"}, replaced: []string{fp(aliceKey)},
+		},
+		{
+			name: "by key_id", identity: IdentityKeyID, keys: []config.KeySpec{{Key: daveKey + " new-laptop", KeyID: "ci"}},
+			file:     bobKey + " old-laptop key_id=ci\n" + aliceKey + " alice key_id=other\n",
+			want:     daveKey + " new-laptop key_id=ci\n" + aliceKey + " alice key_id=other\n",
+			statuses: []string{"inline: replaced"}, replaced: []string{fp(bobKey)},
+		},
+		{
+			name: "several old keys share the identity", identity: IdentityComment, keys: inlineKeys(daveKey + " alice@corp"),
+			file:     aliceKey + " alice@corp\n" + bobKey + " bob\n" + carolKey + " alice@corp\n",
+			want:     daveKey + " alice@corp\n" + bobKey + " bob\n",
+			statuses: []string{"inline: replaced"}, replaced: []string{fp(aliceKey), fp(carolKey)},
+		},
+		{
+			name: "empty comment never matches", identity: IdentityComment, keys: inlineKeys(daveKey),
+			file:     aliceKey + "\n" + bobKey + " key_id=x\n",
+			want:     aliceKey + "\n" + bobKey + " key_id=x\n" + daveKey + "\n",
+			statuses: []string{"inline: added"},
+		},
+		{
+			name: "empty key_id never matches", identity: IdentityKeyID, keys: inlineKeys(daveKey + " dave"),
+			file:     aliceKey + " alice\n",
+			want:     aliceKey + " alice\n" + daveKey + " dave\n",
+			statuses: []string{"inline: added"},
+		},
+		{
+			name: "configured keys are never replaced", identity: IdentityComment, keys: inlineKeys(aliceKey+" team", daveKey+" team"),
+			file:     aliceKey + " team\n" + bobKey + " team\n",
+			want:     aliceKey + " team\n" + daveKey + " team\n",
+			statuses: []string{"inline: unchanged", "inline: replaced"}, replaced: []string{fp(bobKey)},
+		},
+		{
+			name: "no identity", keys: inlineKeys(daveKey + " alice@corp"),
+			file:     aliceKey + " alice@corp\n",
+			want:     aliceKey + " alice@corp\n" + daveKey + " alice@corp\n",
+			statuses: []string{"inline: added"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, fs := testHost(config.Server{PublicKeys: tt.keys, Identity: tt.identity}, tt.file)
+			results, err := h.Update()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := statuses(results); !reflect.DeepEqual(got, tt.statuses) {
+				t.Errorf("got %q, want %q", got, tt.statuses)
+			}
+			var replaced []string
+			for _, r := range results {
+				replaced = append(replaced, r.Replaced...)
+			}
+			if !reflect.DeepEqual(replaced, tt.replaced) {
+				t.Errorf("replaced %q, want %q", replaced, tt.replaced)
+			}
+			if got := fs.files[testAuthorizedKeys]; got != tt.want {
+				t.Errorf("file =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+	"github.com/thineshsubramani/sync-ssh-id/internal/util"
+)
+
+// Key result statuses reported per key.
+const (
+	KeyAdded     = "added"
+	KeyPresent   = "present"
+	KeyUpdated   = "updated"
+	KeyRemoved   = "removed"
+	KeyAbsent    = "absent"
+	KeyFailed    = "failed"
+	KeyUnchanged = "unchanged"
+	KeyPruned    = "pruned"    // removed by exclusive mode because it is not in the inventory
+	KeyUnmanaged = "unmanaged" // present outside the managed block, left untouched
+	KeyReplaced  = "replaced"  // update swapped out older keys with the same identity
+	KeyExpired   = "expired"   // removed by prune-expired because its expiry has passed
+)
+
+// Identities update can match previous keys by (see config.Server.Identity).
+const (
+	IdentityComment = "comment"
+	IdentityKeyID   = "key_id"
+)
+
+// LocalKey is a public key resolved from the inventory, ready to be written remotely.
+type LocalKey struct {
+	Source string         // path the key came from, or "inline"
+	Key    authkeys.Entry // parsed key (its comment carries the key_id annotation, if any)
+	Line   string         // authorized_keys line including rendered options
+}
+
+// KeyResult is the outcome of an operation for one key on one host.
+type KeyResult struct {
+	Source      string
+	Fingerprint string
+	Status      string
+	Replaced    []string  // fingerprints of the older keys a "replaced" update removed
+	Expired     time.Time // when an "expired" key expired
+	Err         error
+}
+
+// resolveKeys expands public_key and public_keys into the list of keys to apply.
+// Sources that cannot be read or parsed are reported as failed results and skipped,
+// so one bad entry does not block the others.
+func (k *KeyManager) resolveKeys(s config.Server) ([]LocalKey, []KeyResult) {
+	specs := make([]config.KeySpec, 0, len(s.PublicKeys)+1)
+	if strings.TrimSpace(s.PublicKey) != "" || len(s.PublicKeys) == 0 {
+		specs = append(specs, config.KeySpec{Key: s.PublicKey, KeyID: s.KeyID, Expires: s.Expires})
+	}
+	specs = append(specs, s.PublicKeys...)
+
+	var keys []LocalKey
+	var failed []KeyResult
+	seen := map[string]int{} // fingerprint -> index in keys
+	for _, spec := range specs {
+		opts := spec.Options
+		if opts == nil {
+			opts = s.KeyOptions
+		}
+		found, err := k.loadKeySource(spec)
+		if err == nil {
+			err = opts.Validate()
+		}
+		var expires time.Time
+		if err == nil && strings.TrimSpace(spec.Expires) != "" {
+			if expires, err = authkeys.ParseExpires(spec.Expires); err == nil {
+				opts = withExpiryTime(opts, expires)
+			}
+		}
+		if err != nil {
+			failed = append(failed, KeyResult{Source: keySourceLabel(spec.Source()), Status: KeyFailed, Err: err})
+			continue
+		}
+		for _, lk := range found {
+			if id := strings.TrimSpace(spec.KeyID); id != "" {
+				lk.Key.Comment = authkeys.WithKeyID(lk.Key.Comment, id)
+			}
+			if !expires.IsZero() {
+				lk.Key.Comment = authkeys.WithExpires(lk.Key.Comment, expires)
+			}
+			lk.Line = lk.Key.Render(opts.Strings())
+			// the same key listed twice: an entry with its own options wins, otherwise the first one
+			if i, ok := seen[lk.Key.Fingerprint()]; ok {
+				if spec.Options != nil {
+					keys[i] = lk
+				}
+				continue
+			}
+			seen[lk.Key.Fingerprint()] = len(keys)
+			keys = append(keys, lk)
+		}
+	}
+	return keys, failed
+}
+
+// withExpiryTime returns a copy of opts with expiry-time set to t; expires wins over options.expiry_time.
+func withExpiryTime(opts *config.KeyOptions, t time.Time) *config.KeyOptions {
+	var o config.KeyOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.ExpiryTime = authkeys.FormatExpiryTime(t)
+	return &o
+}
+
+// loadKeySource reads the keys of one public_keys entry: those served at its url, or those named by its key.
+func (k *KeyManager) loadKeySource(spec config.KeySpec) ([]LocalKey, error) {
+	if u := strings.TrimSpace(spec.URL); u != "" {
+		return k.loadURLKeys(u, spec.Fingerprints)
+	}
+	return k.loadKeySpec(spec.Key)
+}
+
+// loadKeySpec reads the keys named by src: an inline key, an https:// keys endpoint,
+// a glob, or a file path (default: the first of ~/.ssh/id_ed25519.pub, id_ecdsa.pub and
+// id_rsa.pub that exists). A file may hold several keys.
+func (k *KeyManager) loadKeySpec(src string) ([]LocalKey, error) {
+	src = strings.TrimSpace(src)
+	if isKeysURL(src) {
+		return k.loadURLKeys(src, nil)
+	}
+	if isInlineKey(src) {
+		e, err := authkeys.ParsePublicKey([]byte(src))
+		if err != nil {
+			return nil, fmt.Errorf("invalid inline public key: %w", err)
+		}
+		return []LocalKey{{Source: "inline", Key: e}}, nil
+	}
+
+	pubPath := util.ExpandPath(src)
+	hint := ""
+	if pubPath == "" {
+		pubPath = util.DetectDefaultPubKey()
+		hint = " (create one with sync-ssh-id keygen or --generate-if-missing)"
+	}
+
+	paths := []string{pubPath}
+	if strings.ContainsAny(pubPath, "*?[") {
+		matches, err := filepath.Glob(pubPath)
+		if err != nil {
+			return nil, fmt.Errorf("bad glob %s: %w", pubPath, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no public keys match %s", pubPath)
+		}
+		paths = matches
+	}
+
+	var out []LocalKey
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return nil, fmt.Errorf("public key not found: %s%s", p, hint)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("read pubkey: %w", err)
+		}
+		entries, err := authkeys.ParsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", p, err)
+		}
+		for _, e := range entries {
+			out = append(out, LocalKey{Source: p, Key: e})
+		}
+	}
+	return out, nil
+}
+
+// isInlineKey reports whether src is key material rather than a path.
+func isInlineKey(src string) bool {
+	e, err := authkeys.ParseLine(src)
+	return err == nil && e.IsKey()
+}
+
+// keySourceLabel shortens inline key material for output.
+func keySourceLabel(src string) string {
+	if isInlineKey(src) {
+		return "inline"
+	}
+	if strings.TrimSpace(src) == "" {
+		return "(default)"
+	}
+	return src
+}
@@ -0,0 +1,182 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// lockBusyStatus is the exit status of lockScript when another run still holds the lock.
+const lockBusyStatus = 75
+
+// lockGrace is how much longer than the lock timeout Lock waits for lockScript to answer.
+const lockGrace = 15 * time.Second
+
+// lockStaleMinutes is how long an SFTP-held lock (one without a pid) may go without a
+// heartbeat before a shell waiter takes it over.
+const lockStaleMinutes = 2
+
+// lockScript takes an advisory lock on the directory %[1]s.d (next to authorized_keys), waiting
+// up to %[3]d seconds, prints "locked" and holds the lock until its stdin is closed. A lock left
+// behind by a process that no longer exists, or an SFTP holder whose heartbeat stopped more
+// than %[4]d minutes ago, is taken over. The holder's description %[2]s is recorded so a waiting
+// run can say who has it. Runs over SFTP take the same directory (see lockSFTP).
+const lockScript = `umask 077
+lock=%[1]s
+l="$lock.d"
+d=$(dirname "$lock")
+[ -d "$d" ] || { mkdir -p "$d" && chmod 700 "$d"; } || exit 1
+owner=%[2]s" since $(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)"
+n=0
+while ! mkdir "$l" 2>/dev/null; do
+  pid=$(cat "$l/pid" 2>/dev/null)
+  stale=
+  if [ -n "$pid" ]; then
+    [ -d /proc/1 ] && [ ! -d "/proc/$pid" ] && stale=1
+  else
+    f="$l/owner"
+    [ -f "$f" ] || f="$l"
+    [ -n "$(find "$f" -prune -mmin +%[4]d 2>/dev/null)" ] && stale=1
+  fi
+  if [ -n "$stale" ]; then
+    mv "$l" "$l.stale.$$" 2>/dev/null && rm -rf "$l.stale.$$"
+    continue
+  fi
+  if [ $n -ge %[3]d ]; then
+    who=$(cat "$l/owner" 2>/dev/null)
+    echo "locked by ${who:-another process}" >&2
+    exit 75
+  fi
+  sleep 1
+  n=$((n+1))
+done
+trap 'rm -rf "$l"' EXIT
+trap 'exit 1' HUP INT TERM
+echo $$ > "$l/pid"
+printf '%%s\n' "$owner" > "$l/owner"
+echo locked
+read -r _
+exit 0`
+
+// remoteLock is a lock held on the host; release gives it up.
+type remoteLock struct {
+	release func() error
+}
+
+// Lock takes the advisory lock next to the host's authorized_keys, waiting up to
+// KeyManager.LockTimeout for another run to release it. Over SFTP the lock is taken with SFTP
+// requests only, so hosts without a usable shell can be locked too. The lock is held until Close,
+// so everything done through the Host in between is one transaction. Locking twice is a no-op.
+func (h *Host) Lock() error {
+	if h.lock != nil {
+		return nil
+	}
+	akPath, err := h.keysPath()
+	if err != nil {
+		return err
+	}
+	defer h.phase("lock")()
+
+	lockPath := akPath + ".lock"
+	if f, ok := h.fs.(*sftpFS); ok {
+		return h.lockSFTP(f, lockPath)
+	}
+	secs := int(math.Ceil(h.k.LockTimeout.Seconds()))
+	cmd := fmt.Sprintf(lockScript, shellPath(lockPath), escapeForSingleQuotes(lockOwner()), secs, lockStaleMinutes)
+	var input []byte
+	if sh, ok := h.fs.(*shellFS); ok {
+		if sh.become != "" {
+			// a refused sudo would leave the lock session waiting on stdin, so check it first
+			if err := sh.exec("true", nil); err != nil {
+				return fmt.Errorf("lock %s: %w", lockPath, err)
+			}
+		}
+		cmd, input = sh.wrap(cmd, nil)
+	}
+
+	session, err := h.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		return fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+	if len(input) > 0 {
+		_, _ = stdin.Write(input)
+	}
+
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		answer <- strings.TrimSpace(line)
+	}()
+	select {
+	case line := <-answer:
+		if line == "locked" {
+			h.lock = &remoteLock{release: func() error {
+				_ = stdin.Close()
+				defer session.Close()
+				return session.Wait()
+			}}
+			return nil
+		}
+	case <-time.After(h.k.LockTimeout + lockGrace):
+	}
+
+	_ = stdin.Close()
+	err = session.Wait()
+	session.Close()
+	msg := strings.TrimSpace(stderr.String())
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) && exit.ExitStatus() == lockBusyStatus {
+		return fmt.Errorf("%s is %s (gave up after %s)", lockPath, msg, h.k.LockTimeout)
+	}
+	return fmt.Errorf("lock %s: %v: %s", lockPath, err, msg)
+}
+
+// unlock releases the lock taken by Lock, if any.
+func (h *Host) unlock() error {
+	l := h.lock
+	if l == nil {
+		return nil
+	}
+	h.lock = nil
+	if err := l.release(); err != nil {
+		return fmt.Errorf("unlock: %w", err)
+	}
+	return nil
+}
+
+// lockOwner describes this run for the "locked by" message, e.g. "alice@laptop (pid 4242)".
+func lockOwner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s (pid %d)", name, host, os.Getpid())
+}
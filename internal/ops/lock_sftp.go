@@ -0,0 +1,147 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockHeartbeat is how often an SFTP-held lock rewrites its owner file. A waiter that sees the
+// owner file unchanged for lockSFTPStaleAfter (timed on its own clock) takes the lock over; shell
+// waiters use lockStaleMinutes on the host's clock instead.
+const (
+	lockHeartbeat      = 5 * time.Second
+	lockSFTPStaleAfter = 4 * lockHeartbeat
+)
+
+// lockSFTP takes the lock directory lockPath+".d" with an atomic SFTP mkdir, the same directory
+// lockScript uses, so SFTP and shell runs exclude each other. The owner file is rewritten every
+// lockHeartbeat while the lock is held; a holder without a pid file whose heartbeat stopped, or a
+// shell holder whose process is gone from /proc, is taken over.
+func (h *Host) lockSFTP(f *sftpFS, lockPath string) error {
+	dir := f.resolve(lockPath + ".d")
+	ownerPath := path.Join(dir, "owner")
+	owner := lockOwner() + " since " + time.Now().UTC().Format("2006-01-02T15:04:05Z") + "\n"
+	if err := f.MkdirAll(path.Dir(lockPath), 0o700); err != nil {
+		return fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+
+	deadline := time.Now().Add(h.k.LockTimeout)
+	var seen time.Time   // owner mtime last observed
+	var seenAt time.Time // when it was first observed with that value
+	for {
+		err := f.c.Mkdir(dir)
+		if err == nil {
+			break
+		}
+		fi, serr := f.c.Stat(dir)
+		if errors.Is(serr, os.ErrNotExist) {
+			continue // released between our mkdir and stat
+		}
+		if serr != nil || !fi.IsDir() {
+			return fmt.Errorf("lock %s: %w", lockPath, err)
+		}
+
+		stale := false
+		if pid := strings.TrimSpace(string(readLockFile(f, path.Join(dir, "pid")))); pid != "" {
+			stale = f.procGone(pid)
+		} else {
+			mtime := fi.ModTime()
+			if ofi, err := f.c.Stat(ownerPath); err == nil {
+				mtime = ofi.ModTime()
+			}
+			if !mtime.Equal(seen) {
+				seen, seenAt = mtime, time.Now()
+			}
+			stale = time.Since(seenAt) >= lockSFTPStaleAfter
+		}
+		if stale {
+			// rename first so that of several waiters only one removes it
+			tmp := fmt.Sprintf("%s.stale.%d", dir, time.Now().UnixNano())
+			if f.c.Rename(dir, tmp) == nil {
+				removeLockDir(f, tmp)
+			}
+			seen, seenAt = time.Time{}, time.Time{}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			who := strings.TrimSpace(string(readLockFile(f, ownerPath)))
+			if who == "" {
+				who = "another process"
+			}
+			return fmt.Errorf("%s is locked by %s (gave up after %s)", lockPath, who, h.k.LockTimeout)
+		}
+		time.Sleep(time.Second)
+	}
+	_ = f.c.Chmod(dir, 0o700)
+	if err := writeLockFile(f, ownerPath, owner); err != nil {
+		removeLockDir(f, dir)
+		return fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(lockHeartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				_ = writeLockFile(f, ownerPath, owner)
+			}
+		}
+	}()
+	h.lock = &remoteLock{release: func() error {
+		close(stop)
+		wg.Wait()
+		return removeLockDir(f, dir)
+	}}
+	return nil
+}
+
+// procGone reports whether the host's /proc shows that pid no longer exists. It is false when
+// /proc cannot be seen (e.g. a chrooted SFTP server), so such locks are never taken over.
+func (f *sftpFS) procGone(pid string) bool {
+	if _, err := f.c.Stat("/proc/1"); err != nil {
+		return false
+	}
+	_, err := f.c.Stat("/proc/" + pid)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// readLockFile returns the contents of a small file, or nil if it cannot be read.
+func readLockFile(f *sftpFS, p string) []byte {
+	data, _ := f.ReadFile(p)
+	return data
+}
+
+func writeLockFile(f *sftpFS, p, data string) error {
+	w, err := f.c.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// removeLockDir deletes a lock directory and the few files in it.
+func removeLockDir(f *sftpFS, dir string) error {
+	if entries, err := f.c.ReadDir(dir); err == nil {
+		for _, e := range entries {
+			_ = f.c.Remove(path.Join(dir, e.Name()))
+		}
+	}
+	return f.c.RemoveDirectory(dir)
+}
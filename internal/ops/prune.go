@@ -0,0 +1,73 @@
+package ops
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// PruneExpired removes every key line whose expiry has passed from the remote authorized_keys
+// (only the managed block when the server uses one) and reports each removal. A key's expiry
+// is its expires annotation, or else its expiry-time option; keys without either are kept.
+// authorized_keys is backed up and rewritten only if something expired.
+func (k *KeyManager) PruneExpired(s config.Server) ([]KeyResult, error) {
+	return withLockedHost(k, s, (*Host).PruneExpired)
+}
+
+// PruneExpired is KeyManager.PruneExpired on an open connection.
+func (h *Host) PruneExpired() ([]KeyResult, error) {
+	s, k, fs := h.s, h.k, h.fs
+	akPath, err := h.keysPath()
+	if err != nil {
+		return nil, err
+	}
+	done := h.phase("read")
+	doc, err := k.remoteReadAuthorizedKeys(fs, akPath, s.UsesManagedBlock())
+	done()
+	if err != nil {
+		return nil, fmt.Errorf("read authorized_keys: %w", err)
+	}
+
+	now := time.Now()
+	var results []KeyResult
+	var expired []int // line numbers: a re-grant of the same key on another line stays
+	for _, e := range doc.Block.Keys() {
+		src := e.BaseComment()
+		if src == "" {
+			src = fmt.Sprintf("line %d", e.Line)
+		}
+		t, ok, err := e.Expires()
+		if err != nil {
+			results = append(results, KeyResult{Source: src, Fingerprint: e.Fingerprint(), Status: KeyFailed, Err: err})
+			continue
+		}
+		if !ok || t.After(now) {
+			continue
+		}
+		expired = append(expired, e.Line)
+		results = append(results, KeyResult{Source: src, Fingerprint: e.Fingerprint(), Status: KeyExpired, Expired: t})
+	}
+	if len(expired) == 0 {
+		return results, nil
+	}
+	for _, line := range expired {
+		doc.Block.RemoveLine(line)
+	}
+
+	if s.BacksUpAuthorizedKeys() {
+		done := h.phase("backup")
+		err := k.backupAuthorizedKeys(fs, akPath)
+		done()
+		if err != nil {
+			return results, fmt.Errorf("backup authorized_keys: %w", err)
+		}
+	}
+	done = h.phase("write")
+	err = k.remoteWriteAuthorizedKeys(fs, akPath, doc.Bytes())
+	done()
+	if err != nil {
+		return markFailed(results, err), err
+	}
+	return results, nil
+}
@@ -3,63 +3,307 @@ package ops
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path"
+	"strconv"
 	"strings"
-	"time"
 
 	"golang.org/x/crypto/ssh"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
 )
 
 // runRemote runs a single shell command on the remote client and returns combined output.
 func runRemote(client *ssh.Client, cmd string) (string, error) {
+	return runRemoteInput(client, cmd, nil)
+}
+
+// runRemoteInput is runRemote with stdin fed from input (may be nil), returning stdout followed by stderr.
+func runRemoteInput(client *ssh.Client, cmd string, input []byte) (string, error) {
+	stdout, stderr, err := runRemoteSplit(client, cmd, input)
+	return string(stdout) + string(stderr), err
+}
+
+// runRemoteSplit runs cmd with stdin fed from input (may be nil) and returns stdout and stderr.
+// They are collected separately: the session copies them concurrently, and a shared
+// bytes.Buffer would lose data.
+func runRemoteSplit(client *ssh.Client, cmd string, input []byte) ([]byte, []byte, error) {
 	session, err := client.NewSession()
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	defer session.Close()
-	var b bytes.Buffer
-	session.Stdout = &b
-	session.Stderr = &b
-	if err := session.Run(cmd); err != nil {
-		return b.String(), err
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if input != nil {
+		session.Stdin = bytes.NewReader(input)
+	}
+	err = session.Run(cmd)
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// ensureKeyDir creates the directory holding the authorized_keys file at akPath;
+// the default ~/.ssh is also forced to mode 700.
+func (k *KeyManager) ensureKeyDir(fs remoteFS, akPath string) error {
+	dir := path.Dir(akPath)
+	if dir == "~/.ssh" {
+		return k.ensureSSHDir(fs)
+	}
+	return fs.MkdirAll(dir, 0o700)
+}
+
+func (k *KeyManager) ensureSSHDir(fs remoteFS) error {
+	if err := fs.MkdirAll("~/.ssh", 0o700); err != nil {
+		return err
+	}
+	return fs.Chmod("~/.ssh", 0o700)
+}
+
+// remoteReadAuthorizedKeys fetches and parses the remote authorized_keys file.
+// With managed set only the sync-ssh-id block is editable.
+func (k *KeyManager) remoteReadAuthorizedKeys(fs remoteFS, path string, managed bool) (*authkeys.Document, error) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return authkeys.ParseDocument(data, managed)
+}
+
+// remoteWriteAuthorizedKeys atomically replaces path with data (mode 600 if the file is new).
+func (k *KeyManager) remoteWriteAuthorizedKeys(fs remoteFS, path string, data []byte) error {
+	if err := fs.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// shellFS implements remoteFS with POSIX shell one-liners over exec sessions.
+// With become set every command runs as that user through sudo (see sudoScript).
+//
+// Steps whose only result is success or failure (MkdirAll, Chmod, Copy, Remove, Chown) are queued
+// and sent in the same session as the next command that needs output, or by Flush, so a typical
+// inject costs three sessions instead of one per step. A failing step stops the script and its
+// label is reported in the error of the call that flushed it.
+type shellFS struct {
+	client     *ssh.Client
+	become     string // target user; "" runs commands as the login user
+	becomePass string // sudo password, only sent when sudo asks for one
+	pending    []shellStep
+	sessions   int // exec sessions opened, for verbose timings
+}
+
+// shellStep is a queued command and the label reported if it fails.
+type shellStep struct {
+	label string
+	cmd   string
+}
+
+// sudoScript runs a command as another user. The first stdin line is the sudo password; it is
+// piped to sudo only if sudo actually needs one, so with NOPASSWD or cached credentials it can
+// never end up in the command's input. The rest of stdin is passed through to the command.
+// -H points HOME (and so "~/") at the target user's home directory.
+const sudoScript = `IFS= read -r p
+if sudo -n -H -u %[1]s true 2>/dev/null; then
+  exec sudo -n -H -u %[1]s sh -c %[2]s
+fi
+{ printf '%%s\n' "$p"; exec cat; } | sudo -S -p '' -H -u %[1]s sh -c %[2]s`
+
+// run executes the queued steps followed by cmd (as the become user if set) with input on stdin.
+func (f *shellFS) run(cmd string, input []byte) ([]byte, []byte, error) {
+	if len(f.pending) > 0 {
+		var b strings.Builder
+		for _, st := range f.pending {
+			fmt.Fprintf(&b, "{ %s\n} || { echo %s >&2; exit 1; }\n", st.cmd, escapeForSingleQuotes(st.label+" failed"))
+		}
+		b.WriteString(cmd)
+		cmd = b.String()
+		f.pending = nil
+	}
+
+	f.sessions++
+	cmd, input = f.wrap(cmd, input)
+	return runRemoteSplit(f.client, cmd, input)
+}
+
+// wrap returns cmd and its stdin adjusted to run as the become user (unchanged without become).
+func (f *shellFS) wrap(cmd string, input []byte) (string, []byte) {
+	if f.become == "" {
+		return cmd, input
+	}
+	wrapped := fmt.Sprintf(sudoScript, escapeForSingleQuotes(f.become), escapeForSingleQuotes(cmd))
+	return wrapped, append([]byte(f.becomePass+"\n"), input...)
+}
+
+// exec is run for commands whose output only matters on failure.
+func (f *shellFS) exec(cmd string, input []byte) error {
+	stdout, stderr, err := f.run(cmd, input)
+	if err != nil {
+		return fmt.Errorf("%v: %s%s", err, stdout, stderr)
+	}
+	return nil
+}
+
+// queue defers cmd until the next command or Flush.
+func (f *shellFS) queue(label, cmd string) error {
+	f.pending = append(f.pending, shellStep{label: label, cmd: cmd})
+	return nil
+}
+
+func (f *shellFS) Flush() error {
+	if len(f.pending) == 0 {
+		return nil
 	}
-	return b.String(), nil
+	return f.exec(":", nil)
 }
 
-func (k *KeyManager) ensureSSHDir(client *ssh.Client) error {
-	_, err := runRemote(client, "mkdir -p ~/.ssh && chmod 700 ~/.ssh")
-	return err
+func (f *shellFS) Name() string { return TransportShell }
+
+func (f *shellFS) ReadFile(path string) ([]byte, error) {
+	p := shellPath(path)
+	stdout, stderr, err := f.run(fmt.Sprintf("if [ -f %s ]; then cat %s; fi", p, p), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr)
+	}
+	return stdout, nil
 }
 
-func (k *KeyManager) backupAuthorizedKeys(client *ssh.Client) error {
-	ts := time.Now().UTC().Format("20060102T150405Z")
-	_, err := runRemote(client, fmt.Sprintf("if [ -f ~/.ssh/authorized_keys ]; then cp ~/.ssh/authorized_keys ~/.ssh/authorized_keys.bak.%s; fi", ts))
-	return err
+// atomicWriteScript resolves symlinks, clones the target (cp -p keeps mode and, as root, owner)
+// into a per-process temp file next to it, overwrites the clone from stdin, checks the size,
+// syncs and renames it into place. The temp file is removed on any failure.
+const atomicWriteScript = `t=%s
+n=0
+while [ -L "$t" ] && [ $n -lt 40 ]; do
+  l=$(readlink "$t") || exit 1
+  case "$l" in /*) t="$l" ;; *) t="$(dirname "$t")/$l" ;; esac
+  n=$((n+1))
+done
+tmp="$t.sync-ssh-id.$$"
+trap 'rm -f "$tmp"' EXIT
+umask 077
+rm -f "$tmp"
+if [ -f "$t" ]; then cp -p "$t" "$tmp" || exit 1; fi
+cat > "$tmp" || exit 1
+[ "$(($(wc -c < "$tmp")))" -eq %d ] || { echo "short write" >&2; exit 1; }
+[ -f "$t" ] || chmod %o "$tmp" || exit 1
+sync "$tmp" 2>/dev/null || sync
+mv -f "$tmp" "$t"`
+
+func (f *shellFS) WriteFile(path string, data []byte, mode os.FileMode) error {
+	return f.exec(fmt.Sprintf(atomicWriteScript, shellPath(path), len(data), mode), data)
 }
 
-func (k *KeyManager) remoteHasExactLine(client *ssh.Client, line string) (bool, error) {
-	escaped := escapeForSingleQuotes(line)
-	cmd := fmt.Sprintf("if [ -f ~/.ssh/authorized_keys ]; then grep -F -x %s ~/.ssh/authorized_keys >/dev/null 2>&1 && echo present || echo absent; else echo absent; fi", escaped)
-	out, _ := runRemote(client, cmd)
-	return strings.TrimSpace(out) == "present", nil
+func (f *shellFS) MkdirAll(path string, mode os.FileMode) error {
+	p := shellPath(path)
+	return f.queue("mkdir "+path, fmt.Sprintf("[ -d %s ] || { mkdir -p %s && chmod %o %s; }", p, p, mode, p))
 }
 
-func (k *KeyManager) remoteAppendLine(client *ssh.Client, line string) error {
-	escaped := escapeForSingleQuotes(line)
-	_, err := runRemote(client, fmt.Sprintf("printf '%%s\\n' %s >> ~/.ssh/authorized_keys", escaped))
-	return err
+func (f *shellFS) Chmod(path string, mode os.FileMode) error {
+	return f.queue("chmod "+path, fmt.Sprintf("chmod %o %s", mode, shellPath(path)))
 }
 
-// remoteRemoveExactLine removes exact matching lines from authorized_keys
-func (k *KeyManager) remoteRemoveExactLine(client *ssh.Client, line string) error {
-	escaped := escapeForSingleQuotes(line)
-	// Ensure grep failure (exit 1) doesn't stop the pipeline; redirect will still create tmp file.
-	// Using '|| true' ensures the shell command exits 0, so SSH session.Run sees success.
-	cmd := fmt.Sprintf("if [ -f ~/.ssh/authorized_keys ]; then grep -v -F -x %s ~/.ssh/authorized_keys > /tmp/authorized_keys.tmp || true; mv /tmp/authorized_keys.tmp ~/.ssh/authorized_keys; fi", escaped)
-	_, err := runRemote(client, cmd)
-	return err
+func (f *shellFS) Copy(src, dst string) error {
+	s, d := shellPath(src), shellPath(dst)
+	return f.queue("copy "+src, fmt.Sprintf("if [ -f %s ]; then cp %s %s; fi", s, s, d))
 }
 
-func (k *KeyManager) remoteChmod(client *ssh.Client, path, mode string) error {
-	_, err := runRemote(client, fmt.Sprintf("chmod %s %s", mode, path))
-	return err
+func (f *shellFS) ReadDir(dir string) ([]string, error) {
+	d := shellPath(dir)
+	stdout, stderr, err := f.run(fmt.Sprintf("if [ -d %s ]; then ls -1a %s; fi", d, d), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s%s", err, stdout, stderr)
+	}
+	var names []string
+	for _, n := range strings.Split(string(stdout), "\n") {
+		if n != "" && n != "." && n != ".." {
+			names = append(names, n)
+		}
+	}
+	return names, nil
 }
+
+func (f *shellFS) Remove(path string) error {
+	return f.queue("remove "+path, fmt.Sprintf("rm -f %s", shellPath(path)))
+}
+
+func (f *shellFS) Home() (string, error) {
+	stdout, stderr, err := f.run(`printf '%s' "$HOME"`, nil)
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, stderr)
+	}
+	if len(stdout) == 0 {
+		return "", fmt.Errorf("HOME is not set")
+	}
+	return string(stdout), nil
+}
+
+func (f *shellFS) UID() (int, error) {
+	stdout, stderr, err := f.run("id -u", nil)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %s", err, stderr)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(stdout)))
+}
+
+// Stat parses `ls -ldnL`, which unlike stat(1) has the same output on GNU, BSD and busybox.
+func (f *shellFS) Stat(path string) (remoteStat, error) {
+	p := shellPath(path)
+	stdout, stderr, err := f.run(fmt.Sprintf("if [ -e %s ]; then ls -ldnL %s; fi", p, p), nil)
+	if err != nil {
+		return remoteStat{}, fmt.Errorf("%v: %s", err, stderr)
+	}
+	fields := strings.Fields(string(stdout))
+	if len(fields) == 0 {
+		return remoteStat{}, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+	if len(fields) < 4 {
+		return remoteStat{}, fmt.Errorf("%s: unexpected ls output %q", path, stdout)
+	}
+	mode, err := parseLsMode(fields[0])
+	if err != nil {
+		return remoteStat{}, fmt.Errorf("%s: %w", path, err)
+	}
+	uid, err1 := strconv.Atoi(fields[2])
+	gid, err2 := strconv.Atoi(fields[3])
+	if err1 != nil || err2 != nil {
+		return remoteStat{}, fmt.Errorf("%s: unexpected ls output %q", path, stdout)
+	}
+	return remoteStat{Mode: mode, UID: uid, GID: gid}, nil
+}
+
+// parseLsMode converts an ls mode string such as "drwxr-sr-x." into an os.FileMode.
+func parseLsMode(s string) (os.FileMode, error) {
+	if len(s) < 10 {
+		return 0, fmt.Errorf("bad mode %q", s)
+	}
+	var m os.FileMode
+	if s[0] == 'd' {
+		m |= os.ModeDir
+	}
+	for i, c := range s[1:10] {
+		bit := os.FileMode(1) << uint(8-i)
+		switch c {
+		case 'r', 'w', 'x':
+			m |= bit
+		case 's':
+			m |= bit
+			m |= map[int]os.FileMode{2: os.ModeSetuid, 5: os.ModeSetgid}[i]
+		case 'S':
+			m |= map[int]os.FileMode{2: os.ModeSetuid, 5: os.ModeSetgid}[i]
+		case 't':
+			m |= bit | os.ModeSticky
+		case 'T':
+			m |= os.ModeSticky
+		case '-':
+		default:
+			return 0, fmt.Errorf("bad mode %q", s)
+		}
+	}
+	return m, nil
+}
+
+func (f *shellFS) Chown(path string, uid, gid int) error {
+	return f.queue("chown "+path, fmt.Sprintf("chown %d:%d %s", uid, gid, shellPath(path)))
+}
+
+func (f *shellFS) Close() error { return nil }
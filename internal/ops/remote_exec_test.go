@@ -0,0 +1,43 @@
+package ops
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseLsMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{in: "-rw-------", want: 0o600},
+		{in: "-rw-r--r--", want: 0o644},
+		{in: "drwx------", want: os.ModeDir | 0o700},
+		{in: "drwxr-xr-x", want: os.ModeDir | 0o755},
+		{in: "-rw-------+", want: 0o600},              // POSIX ACL
+		{in: "drwx------.", want: os.ModeDir | 0o700}, // SELinux context
+		{in: "-rw-r--r--@", want: 0o644},              // macOS extended attributes
+		{in: "drwxr-sr-x.", want: os.ModeDir | os.ModeSetgid | 0o755},
+		{in: "-rwsr-xr-x", want: os.ModeSetuid | 0o755},
+		{in: "-rwSr--r--", want: os.ModeSetuid | 0o644},
+		{in: "drwxr-Sr-x", want: os.ModeDir | os.ModeSetgid | 0o745},
+		{in: "drwxrwxrwt", want: os.ModeDir | os.ModeSticky | 0o777},
+		{in: "drwxrwxrwT", want: os.ModeDir | os.ModeSticky | 0o776},
+		{in: "----------", want: 0},
+		{in: "-rw-r--r", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "-rw-?-----", wantErr: true},
+		{in: "total 8", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLsMode(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLsMode(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseLsMode(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// Transports for remote file access.
+const (
+	TransportAuto  = "auto"  // SFTP when the subsystem is available, shell otherwise
+	TransportSFTP  = "sftp"  // SFTP subsystem only; works with nologin/internal-sftp and non-POSIX shells
+	TransportShell = "shell" // POSIX shell one-liners (mkdir, cat, cp, chmod)
+)
+
+// remoteFS is the file access KeyManager needs on a host.
+// Paths may start with "~/" for the login user's home directory.
+type remoteFS interface {
+	Name() string
+	// ReadFile returns the file content, or nil data and nil error if the file does not exist.
+	ReadFile(path string) ([]byte, error)
+	// WriteFile atomically replaces path with data: it writes a temp file in the target's directory,
+	// syncs it and renames it over the target. Symlinks are followed, the existing mode and owner
+	// are kept, and mode is only used when the file is new.
+	WriteFile(path string, data []byte, mode os.FileMode) error
+	MkdirAll(path string, mode os.FileMode) error
+	Chmod(path string, mode os.FileMode) error
+	// Copy copies src to dst; it is a no-op when src does not exist.
+	Copy(src, dst string) error
+	// ReadDir returns the names of the entries in dir (nil if dir does not exist).
+	ReadDir(dir string) ([]string, error)
+	Remove(path string) error
+	// Home returns the absolute path of the home directory "~/" refers to.
+	Home() (string, error)
+	// UID returns the numeric id of the user the files are managed as.
+	UID() (int, error)
+	// Stat follows symlinks; it returns an error wrapping os.ErrNotExist if path does not exist.
+	Stat(path string) (remoteStat, error)
+	Chown(path string, uid, gid int) error
+	// Flush applies steps a backend may have deferred (MkdirAll, Chmod, Copy, Remove, Chown).
+	// Any other call flushes them too, so Flush is only needed when nothing follows.
+	Flush() error
+	Close() error
+}
+
+// Privilege switching methods for target_user.
+const BecomeSudo = "sudo"
+
+// remoteStat is the part of a remote file's metadata sshd's StrictModes looks at.
+type remoteStat struct {
+	Mode os.FileMode // permission bits plus os.ModeDir for directories
+	UID  int
+	GID  int
+}
+
+// openFS returns the remote file backend selected by the server's transport setting.
+// A server with a target_user always uses the shell transport, with every command run through sudo.
+func (k *KeyManager) openFS(client *ssh.Client, s config.Server) (remoteFS, error) {
+	become, err := becomeUser(s)
+	if err != nil {
+		return nil, err
+	}
+	if become != "" {
+		if t := strings.ToLower(strings.TrimSpace(s.Transport)); t == TransportSFTP {
+			return nil, fmt.Errorf("transport sftp cannot switch to target_user %q; use shell or auto", become)
+		}
+		return &shellFS{client: client, become: become, becomePass: s.BecomePass}, nil
+	}
+
+	switch t := strings.ToLower(strings.TrimSpace(s.Transport)); t {
+	case TransportShell:
+		return &shellFS{client: client}, nil
+	case TransportSFTP:
+		fs, err := newSFTPFS(client)
+		if err != nil {
+			return nil, fmt.Errorf("sftp subsystem: %w", err)
+		}
+		return fs, nil
+	case "", TransportAuto:
+		if fs, err := newSFTPFS(client); err == nil {
+			return fs, nil
+		}
+		return &shellFS{client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q (want sftp, shell or auto)", s.Transport)
+	}
+}
+
+// becomeUser returns the user whose files are managed when it differs from the login user,
+// or "" when no switch is needed.
+func becomeUser(s config.Server) (string, error) {
+	target := strings.TrimSpace(s.TargetUser)
+	if target == "" || target == s.User {
+		return "", nil
+	}
+	switch m := strings.ToLower(strings.TrimSpace(s.Become)); m {
+	case BecomeSudo:
+		return target, nil
+	case "":
+		return "", fmt.Errorf("target_user %q requires become: sudo", target)
+	default:
+		return "", fmt.Errorf("unsupported become method %q (want sudo)", s.Become)
+	}
+}
@@ -0,0 +1,137 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// Rotation and verification statuses reported per key.
+const (
+	KeyVerified     = "verified"     // a fresh login with only this key succeeded
+	KeyNotAccepted  = "not-accepted" // the server refused a login with this key
+	KeyKept         = "kept"         // old key left in place because the new one was not verified
+	KeyUnverifiable = "unverifiable" // verify was requested but no local private key matches
+)
+
+// Rotate replaces s.OldKey with s.NewKey without risking a lockout: the new key is injected
+// (with the server's options), a fresh connection authenticating only with the new private key
+// is opened, and the old key is removed only if that login succeeds.
+func (k *KeyManager) Rotate(s config.Server) ([]KeyResult, error) {
+	return withLockedHost(k, s, (*Host).Rotate)
+}
+
+// Rotate is KeyManager.Rotate on an open connection.
+func (h *Host) Rotate() ([]KeyResult, error) {
+	s, k, fs := h.s, h.k, h.fs
+	if strings.TrimSpace(s.OldKey) == "" || strings.TrimSpace(s.NewKey) == "" {
+		return nil, fmt.Errorf("rotate needs both old_key and new_key")
+	}
+	oldKeys, err := k.loadKeySpec(s.OldKey)
+	if err != nil {
+		return nil, fmt.Errorf("old_key: %w", err)
+	}
+	newKeys, err := k.loadKeySpec(s.NewKey)
+	if err != nil {
+		return nil, fmt.Errorf("new_key: %w", err)
+	}
+	if len(newKeys) != 1 {
+		return nil, fmt.Errorf("new_key: expected exactly one key, found %d", len(newKeys))
+	}
+	if err := s.KeyOptions.Validate(); err != nil {
+		return nil, err
+	}
+	newKey := newKeys[0]
+	newKey.Line = newKey.Key.Render(s.KeyOptions.Strings())
+	for _, old := range oldKeys {
+		if old.Key.SameKey(newKey.Key) {
+			return nil, fmt.Errorf("old_key and new_key are the same key (%s)", newKey.Key.Fingerprint())
+		}
+	}
+
+	akPath, err := h.keysPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := k.ensureKeyDir(fs, akPath); err != nil {
+		return nil, fmt.Errorf("ensure ssh dir: %w", err)
+	}
+	// back up once, before the first write; a rotation that changes nothing leaves no backup
+	backedUp := !s.BacksUpAuthorizedKeys()
+	write := func(doc *authkeys.Document) error {
+		if !backedUp {
+			if err := k.backupAuthorizedKeys(fs, akPath); err != nil {
+				return fmt.Errorf("backup authorized_keys: %w", err)
+			}
+			backedUp = true
+		}
+		return k.remoteWriteAuthorizedKeys(fs, akPath, doc.Bytes())
+	}
+
+	// step 1: inject the new key
+	doc, err := k.remoteReadAuthorizedKeys(fs, akPath, s.UsesManagedBlock())
+	if err != nil {
+		return nil, fmt.Errorf("read authorized_keys: %w", err)
+	}
+	added := KeyResult{Source: newKey.Source, Fingerprint: newKey.Key.Fingerprint(), Status: KeyPresent}
+	if !doc.Contains(newKey.Key) {
+		doc.Block.Append(newKey.Line)
+		if err := write(doc); err != nil {
+			added.Status, added.Err = KeyFailed, err
+			return []KeyResult{added}, err
+		}
+		added.Status = KeyAdded
+	}
+	results := []KeyResult{added}
+
+	// step 2: prove the new key works on its own before touching the old one
+	done := h.phase("verify")
+	err = k.verifyLogin(s, newKey)
+	done()
+	if err != nil {
+		status := KeyFailed
+		if errors.Is(err, errNotAccepted) {
+			status = KeyNotAccepted
+		}
+		results = append(results, KeyResult{Source: newKey.Source, Fingerprint: newKey.Key.Fingerprint(), Status: status, Err: err})
+		for _, old := range oldKeys {
+			results = append(results, KeyResult{Source: old.Source, Fingerprint: old.Key.Fingerprint(), Status: KeyKept})
+		}
+		return results, fmt.Errorf("new key not verified, old key kept: %w", err)
+	}
+	results = append(results, KeyResult{Source: newKey.Source, Fingerprint: newKey.Key.Fingerprint(), Status: KeyVerified})
+
+	// step 3: remove the old key, re-reading in case the file changed meanwhile
+	doc, err = k.remoteReadAuthorizedKeys(fs, akPath, s.UsesManagedBlock())
+	if err != nil {
+		return results, fmt.Errorf("read authorized_keys: %w", err)
+	}
+	changed := false
+	for _, old := range oldKeys {
+		r := KeyResult{Source: old.Source, Fingerprint: old.Key.Fingerprint(), Status: KeyAbsent}
+		switch {
+		case doc.Block.Remove(old.Key) > 0:
+			r.Status = KeyRemoved
+			changed = true
+		case doc.Outside().Contains(old.Key):
+			r.Status = KeyUnmanaged
+		}
+		results = append(results, r)
+	}
+	if !changed {
+		return results, nil
+	}
+	if err := write(doc); err != nil {
+		// the new key is already in place; only the removals failed
+		for i, r := range results {
+			if r.Status == KeyRemoved {
+				results[i].Status, results[i].Err = KeyFailed, err
+			}
+		}
+		return results, err
+	}
+	return results, nil
+}
@@ -0,0 +1,242 @@
+package ops
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpFS implements remoteFS over the SFTP subsystem. No remote shell is involved,
+// so it works for accounts with nologin/internal-sftp, fish/csh login shells and minimal images.
+type sftpFS struct {
+	c      *sftp.Client
+	client *ssh.Client // for the few lookups SFTP has no request for (UID)
+}
+
+func newSFTPFS(client *ssh.Client) (*sftpFS, error) {
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFS{c: c, client: client}, nil
+}
+
+func (f *sftpFS) Name() string { return TransportSFTP }
+
+// resolve maps "~/x" to a path relative to the SFTP start directory, which is the user's home.
+func (f *sftpFS) resolve(p string) string {
+	if p == "~" {
+		return "."
+	}
+	if strings.HasPrefix(p, "~/") {
+		return path.Clean(p[2:])
+	}
+	return p
+}
+
+func (f *sftpFS) Home() (string, error) {
+	return f.c.Getwd()
+}
+
+// UID asks the shell for `id -u`; without an exec channel it falls back to the owner of the home directory.
+func (f *sftpFS) UID() (int, error) {
+	if stdout, _, err := runRemoteSplit(f.client, "id -u", nil); err == nil {
+		if uid, err := strconv.Atoi(strings.TrimSpace(string(stdout))); err == nil {
+			return uid, nil
+		}
+	}
+	st, err := f.Stat("~")
+	if err != nil {
+		return 0, fmt.Errorf("uid: %w", err)
+	}
+	return st.UID, nil
+}
+
+func (f *sftpFS) Stat(p string) (remoteStat, error) {
+	fi, err := f.c.Stat(f.resolve(p))
+	if err != nil {
+		return remoteStat{}, err
+	}
+	st := remoteStat{Mode: fi.Mode() & (os.ModeDir | os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)}
+	if fs, ok := fi.Sys().(*sftp.FileStat); ok {
+		st.UID, st.GID = int(fs.UID), int(fs.GID)
+	}
+	return st, nil
+}
+
+func (f *sftpFS) Chown(p string, uid, gid int) error {
+	return f.c.Chown(f.resolve(p), uid, gid)
+}
+
+// Flush is a no-op: SFTP requests are applied immediately.
+func (f *sftpFS) Flush() error { return nil }
+
+func (f *sftpFS) ReadFile(p string) ([]byte, error) {
+	r, err := f.c.Open(f.resolve(p))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (f *sftpFS) WriteFile(p string, data []byte, mode os.FileMode) error {
+	target, err := f.followLinks(f.resolve(p))
+	if err != nil {
+		return err
+	}
+
+	// keep mode and owner of an existing target
+	uid, gid := -1, -1
+	if fi, err := f.c.Stat(target); err == nil {
+		mode = fi.Mode().Perm()
+		if st, ok := fi.Sys().(*sftp.FileStat); ok {
+			uid, gid = int(st.UID), int(st.GID)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tmp := fmt.Sprintf("%s.sync-ssh-id.%s", target, randSuffix())
+	w, err := f.c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = w.Close()
+			_ = f.c.Remove(tmp)
+		}
+	}()
+
+	if err := w.Chmod(mode); err != nil {
+		return err
+	}
+	if uid >= 0 {
+		if err := w.Chown(uid, gid); err != nil {
+			return fmt.Errorf("keep owner of %s: %w", target, err)
+		}
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	// fsync@openssh.com is an extension; servers without it still get the rename below
+	if err := w.Sync(); err != nil && !isUnsupported(err) {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := f.c.PosixRename(tmp, target); err != nil {
+		if !isUnsupported(err) {
+			return err
+		}
+		// plain SFTP rename refuses to overwrite: fall back to remove + rename
+		if err := f.c.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := f.c.Rename(tmp, target); err != nil {
+			return err
+		}
+	}
+	committed = true
+	return nil
+}
+
+// followLinks resolves p through any chain of symlinks so writes replace the real file
+// instead of the link.
+func (f *sftpFS) followLinks(p string) (string, error) {
+	for i := 0; i < 40; i++ {
+		fi, err := f.c.Lstat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return p, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return p, nil
+		}
+		l, err := f.c.ReadLink(p)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(l) {
+			l = path.Join(path.Dir(p), l)
+		}
+		p = l
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", p)
+}
+
+// isUnsupported reports whether err is the server saying an operation/extension is not supported.
+func isUnsupported(err error) bool {
+	var se *sftp.StatusError
+	return errors.As(err, &se) && se.FxCode() == sftp.ErrSSHFxOpUnsupported
+}
+
+func randSuffix() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (f *sftpFS) MkdirAll(p string, mode os.FileMode) error {
+	rp := f.resolve(p)
+	if _, err := f.c.Stat(rp); err == nil {
+		return nil
+	}
+	if err := f.c.MkdirAll(rp); err != nil {
+		return err
+	}
+	return f.c.Chmod(rp, mode)
+}
+
+func (f *sftpFS) Chmod(p string, mode os.FileMode) error {
+	return f.c.Chmod(f.resolve(p), mode)
+}
+
+func (f *sftpFS) Copy(src, dst string) error {
+	data, err := f.ReadFile(src)
+	if err != nil || data == nil {
+		return err
+	}
+	return f.WriteFile(dst, data, 0o600)
+}
+
+func (f *sftpFS) ReadDir(dir string) ([]string, error) {
+	entries, err := f.c.ReadDir(f.resolve(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (f *sftpFS) Remove(p string) error {
+	return f.c.Remove(f.resolve(p))
+}
+
+func (f *sftpFS) Close() error {
+	return f.c.Close()
+}
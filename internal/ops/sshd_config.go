@@ -0,0 +1,225 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// RemotePathAuto as remote_path resolves the authorized_keys file from the remote sshd configuration.
+const RemotePathAuto = "auto"
+
+const (
+	sshdConfigPath    = "/etc/ssh/sshd_config"
+	sshdIncludeDepth  = 16
+	sshdDefaultAKFile = ".ssh/authorized_keys"
+)
+
+// sshdTestScript prints the effective sshd configuration for user as seen from this connection's
+// client address. It usually needs root; any failure falls back to parsing sshd_config.
+const sshdTestScript = `PATH="$PATH:/usr/sbin:/sbin"
+a=${SSH_CLIENT%%%% *}
+a=${a:-127.0.0.1}
+sshd -T -C user=%s,host="$a",addr="$a" 2>/dev/null`
+
+// authorizedKeysPath returns the authorized_keys file to manage for s: remotePath itself,
+// ~/.ssh/authorized_keys when it is empty, or the path sshd uses when it is "auto".
+func (k *KeyManager) authorizedKeysPath(client *ssh.Client, fs remoteFS, s config.Server, remotePath string) (string, error) {
+	p := strings.TrimSpace(remotePath)
+	if p == "" {
+		return defaultAuthorizedKeys, nil
+	}
+	if !strings.EqualFold(p, RemotePathAuto) {
+		return p, nil
+	}
+
+	user := s.User
+	if t := strings.TrimSpace(s.TargetUser); t != "" {
+		user = t
+	}
+	files, err := sshdAuthorizedKeysFiles(client, fs, user)
+	if err != nil {
+		return "", fmt.Errorf("resolve AuthorizedKeysFile: %w", err)
+	}
+	if len(files) == 0 || strings.EqualFold(files[0], "none") {
+		return "", fmt.Errorf("sshd has AuthorizedKeysFile none for %s", user)
+	}
+	home, err := fs.Home()
+	if err != nil {
+		return "", fmt.Errorf("remote home: %w", err)
+	}
+	return expandAuthorizedKeysFile(files[0], home, user)
+}
+
+// sshdAuthorizedKeysFiles returns the AuthorizedKeysFile patterns in effect for user:
+// from `sshd -T` when it can be run, otherwise from sshd_config and its Includes,
+// otherwise the sshd default.
+func sshdAuthorizedKeysFiles(client *ssh.Client, fs remoteFS, user string) ([]string, error) {
+	stdout, _, err := runRemoteSplit(client, fmt.Sprintf(sshdTestScript, escapeForSingleQuotes(user)), nil)
+	if err == nil {
+		sc := bufio.NewScanner(bytes.NewReader(stdout))
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) > 1 && strings.EqualFold(fields[0], "authorizedkeysfile") {
+				return fields[1:], nil
+			}
+		}
+	}
+
+	files, found, err := sshdConfigLookup(fs, sshdConfigPath, "AuthorizedKeysFile", 0)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return []string{sshdDefaultAKFile}, nil
+	}
+	return files, nil
+}
+
+// sshdConfigLookup returns the arguments of the first global occurrence of keyword in file,
+// following Include directives like sshd does (first value wins, globs sorted, relative
+// paths under /etc/ssh). Match blocks are skipped since they cannot be evaluated here.
+// A missing or unreadable file counts as not found.
+func sshdConfigLookup(fs remoteFS, file, keyword string, depth int) ([]string, bool, error) {
+	if depth > sshdIncludeDepth {
+		return nil, false, fmt.Errorf("%s: Include nested too deeply", file)
+	}
+	data, err := fs.ReadFile(file)
+	if err != nil || data == nil {
+		return nil, false, nil
+	}
+
+	inMatch := false
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		kw, args := splitSSHDConfigLine(sc.Text())
+		switch {
+		case kw == "":
+			continue
+		case strings.EqualFold(kw, "Match"):
+			inMatch = !(len(args) == 1 && strings.EqualFold(args[0], "all"))
+			continue
+		case inMatch:
+			continue
+		case strings.EqualFold(kw, "Include"):
+			for _, pattern := range args {
+				paths, err := expandSSHDInclude(fs, pattern)
+				if err != nil {
+					return nil, false, err
+				}
+				for _, p := range paths {
+					v, ok, err := sshdConfigLookup(fs, p, keyword, depth+1)
+					if err != nil || ok {
+						return v, ok, err
+					}
+				}
+			}
+		case strings.EqualFold(kw, keyword):
+			return args, true, nil
+		}
+	}
+	return nil, false, sc.Err()
+}
+
+// splitSSHDConfigLine splits one sshd_config line into its keyword and arguments.
+// "Keyword=value" and double-quoted arguments are accepted; comments and blank lines yield "".
+func splitSSHDConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	kw, rest := line, ""
+	if i := strings.IndexAny(line, " \t="); i >= 0 {
+		kw, rest = line[:i], strings.TrimSpace(line[i:])
+		// at most one "=" may separate the keyword from its arguments
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+	}
+
+	var args []string
+	var cur strings.Builder
+	inQuote, have := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			have = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if have {
+				args = append(args, cur.String())
+				cur.Reset()
+				have = false
+			}
+		default:
+			cur.WriteRune(r)
+			have = true
+		}
+	}
+	if have {
+		args = append(args, cur.String())
+	}
+	return kw, args
+}
+
+// expandSSHDInclude resolves one Include argument to the existing files it names, sorted.
+func expandSSHDInclude(fs remoteFS, pattern string) ([]string, error) {
+	if !path.IsAbs(pattern) {
+		pattern = path.Join("/etc/ssh", pattern)
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+	dir, base := path.Split(pattern)
+	names, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Include %s: %w", pattern, err)
+	}
+	var out []string
+	for _, n := range names {
+		if ok, err := path.Match(base, n); err != nil {
+			return nil, fmt.Errorf("Include %s: %w", pattern, err)
+		} else if ok {
+			out = append(out, path.Join(dir, n))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// expandAuthorizedKeysFile expands the %h, %u and %% tokens of an AuthorizedKeysFile pattern
+// and makes relative paths relative to home, as sshd does.
+func expandAuthorizedKeysFile(pattern, home, user string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(pattern) {
+			return "", fmt.Errorf("AuthorizedKeysFile %q: trailing %%", pattern)
+		}
+		i++
+		switch pattern[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'h':
+			b.WriteString(home)
+		case 'u':
+			b.WriteString(user)
+		default:
+			return "", fmt.Errorf("AuthorizedKeysFile %q: unsupported token %%%c", pattern, pattern[i])
+		}
+	}
+	p := b.String()
+	if !path.IsAbs(p) {
+		p = path.Join(home, p)
+	}
+	return path.Clean(p), nil
+}
@@ -0,0 +1,140 @@
+package ops
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitSSHDConfigLine(t *testing.T) {
+	tests := []struct {
+		line string
+		kw   string
+		args []string
+	}{
+		{"AuthorizedKeysFile .ssh/authorized_keys .ssh/authorized_keys2", "AuthorizedKeysFile", []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}},
+		{"AuthorizedKeysFile=.ssh/k", "AuthorizedKeysFile", []string{".ssh/k"}},
+		{"AuthorizedKeysFile = .ssh/k", "AuthorizedKeysFile", []string{".ssh/k"}},
+		{"AuthorizedKeysFile =.ssh/k", "AuthorizedKeysFile", []string{".ssh/k"}},
+		{"AuthorizedKeysFile\t\t.ssh/k", "AuthorizedKeysFile", []string{".ssh/k"}},
+		{"  AuthorizedKeysFile .ssh/k  ", "AuthorizedKeysFile", []string{".ssh/k"}},
+		{`AuthorizedKeysFile "/etc/ssh/keys/%u keys" .ssh/k`, "AuthorizedKeysFile", []string{"/etc/ssh/keys/%u keys", ".ssh/k"}},
+		{`AuthorizedKeysFile=".ssh/a b"`, "AuthorizedKeysFile", []string{".ssh/a b"}},
+		{`Banner ""`, "Banner", []string{""}},
+		{"Match User alice", "Match", []string{"User", "alice"}},
+		{"UsePAM", "UsePAM", nil},
+		{"", "", nil},
+		{"   ", "", nil},
+		{"# AuthorizedKeysFile .ssh/k", "", nil},
+		{"  #AuthorizedKeysFile .ssh/k", "", nil},
+	}
+	for _, tt := range tests {
+		kw, args := splitSSHDConfigLine(tt.line)
+		if kw != tt.kw || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitSSHDConfigLine(%q) = %q, %q; want %q, %q", tt.line, kw, args, tt.kw, tt.args)
+		}
+	}
+}
+
+func TestSSHDConfigLookup(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		want      []string
+		wantFound bool
+		wantErr   string
+	}{
+		{
+			name:  "not set",
+			files: map[string]string{sshdConfigPath: "PermitRootLogin no\n"},
+		},
+		{
+			name:  "missing file",
+			files: map[string]string{},
+		},
+		{
+			name:      "case-insensitive keyword, first value wins",
+			files:     map[string]string{sshdConfigPath: "authorizedkeysfile=.ssh/first\nAuthorizedKeysFile .ssh/second\n"},
+			want:      []string{".ssh/first"},
+			wantFound: true,
+		},
+		{
+			name: "Match blocks skipped",
+			files: map[string]string{sshdConfigPath: "Match User deploy\n  AuthorizedKeysFile /etc/ssh/deploy_keys\n" +
+				"Match all\nAuthorizedKeysFile .ssh/global\n"},
+			want:      []string{".ssh/global"},
+			wantFound: true,
+		},
+		{
+			name:  "nothing after a Match",
+			files: map[string]string{sshdConfigPath: "Match Group admins\nAuthorizedKeysFile /etc/ssh/admins\n"},
+		},
+		{
+			name: "Include glob sorted, relative to /etc/ssh",
+			files: map[string]string{
+				sshdConfigPath:                          "Include sshd_config.d/*.conf\nAuthorizedKeysFile .ssh/main\n",
+				"/etc/ssh/sshd_config.d/50-cloud.conf":  "AuthorizedKeysFile .ssh/cloud\n",
+				"/etc/ssh/sshd_config.d/10-local.conf":  "PasswordAuthentication no\n",
+				"/etc/ssh/sshd_config.d/20-keys.conf":   "AuthorizedKeysFile %h/.ssh/keys\n",
+				"/etc/ssh/sshd_config.d/README":         "AuthorizedKeysFile .ssh/readme\n",
+				"/etc/ssh/sshd_config.d/30-match.conf":  "Match User x\nAuthorizedKeysFile .ssh/x\n",
+				"/etc/ssh/sshd_config.d/40-after.conf":  "AuthorizedKeysFile .ssh/after\n",
+				"/etc/ssh/sshd_config.d/sub/99-no.conf": "AuthorizedKeysFile .ssh/sub\n",
+			},
+			want:      []string{"%h/.ssh/keys"},
+			wantFound: true,
+		},
+		{
+			name: "Include without a match falls through",
+			files: map[string]string{
+				sshdConfigPath:                       "Include /etc/ssh/sshd_config.d/*.conf /etc/ssh/extra\nAuthorizedKeysFile .ssh/main\n",
+				"/etc/ssh/sshd_config.d/10-any.conf": "UsePAM yes\n",
+			},
+			want:      []string{".ssh/main"},
+			wantFound: true,
+		},
+		{
+			name:    "Include loop",
+			files:   map[string]string{sshdConfigPath: "Include /etc/ssh/sshd_config\n"},
+			wantErr: "nested too deeply",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := sshdConfigLookup(mapFS{files: tt.files}, sshdConfigPath, "AuthorizedKeysFile", 0)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || found != tt.wantFound || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, %v, %v; want %q, %v", got, found, err, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestExpandAuthorizedKeysFile(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{pattern: ".ssh/authorized_keys", want: "/home/alice/.ssh/authorized_keys"},
+		{pattern: "%h/.ssh/authorized_keys", want: "/home/alice/.ssh/authorized_keys"},
+		{pattern: "/etc/ssh/keys/%u", want: "/etc/ssh/keys/alice"},
+		{pattern: "/etc/ssh/keys/%u.%%", want: "/etc/ssh/keys/alice.%"},
+		{pattern: "keys/%u/../%u", want: "/home/alice/keys/alice"},
+		{pattern: "%%h", want: "/home/alice/%h"},
+		{pattern: "/var/keys//%u/", want: "/var/keys/alice"},
+		{pattern: ".ssh/keys%", wantErr: true},
+		{pattern: "/keys/%i", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := expandAuthorizedKeysFile(tt.pattern, "/home/alice", "alice")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("expandAuthorizedKeysFile(%q) = %q, %v; want %q, wantErr %v", tt.pattern, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
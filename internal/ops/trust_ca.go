@@ -0,0 +1,170 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+// DefaultCAKeysFile is where trust-ca installs user CA keys when ca_keys_file is unset.
+const DefaultCAKeysFile = "/etc/ssh/trusted_user_ca_keys"
+
+// trust-ca statuses besides the key statuses.
+const (
+	SSHDReloaded = "reloaded"
+	SSHDRestored = "restored" // sshd -t rejected the new sshd_config, so the old one was put back
+)
+
+const sshdValidateScript = `PATH="$PATH:/usr/sbin:/sbin"
+sshd -t`
+
+// sshdReloadScript reloads sshd through systemd, the service wrapper or SIGHUP, whichever exists.
+const sshdReloadScript = `PATH="$PATH:/usr/sbin:/sbin"
+if command -v systemctl >/dev/null 2>&1 && [ -d /run/systemd/system ]; then
+  systemctl reload sshd 2>/dev/null || systemctl reload ssh
+elif command -v service >/dev/null 2>&1; then
+  service sshd reload 2>/dev/null || service ssh reload
+elif [ -f /var/run/sshd.pid ]; then
+  kill -HUP "$(cat /var/run/sshd.pid)"
+else
+  echo "no way to reload sshd found" >&2
+  exit 1
+fi`
+
+// TrustCA makes sshd on the host trust the user CA key(s) in s.CAKey: missing keys are added to
+// the CA keys file, sshd_config is pointed at that file with a global TrustedUserCAKeys line,
+// and if sshd_config changed it is validated with `sshd -t` and sshd is reloaded. A config that
+// fails validation is replaced by the previous one and sshd is left alone. Needs root.
+func (k *KeyManager) TrustCA(s config.Server) ([]KeyResult, error) {
+	return withLockedHost(k, s, (*Host).TrustCA)
+}
+
+// TrustCA is KeyManager.TrustCA on an open connection.
+func (h *Host) TrustCA() ([]KeyResult, error) {
+	s, fs := h.s, h.fs
+	if strings.TrimSpace(s.CAKey) == "" {
+		return nil, fmt.Errorf("trust-ca needs ca_key")
+	}
+	cas, err := h.k.loadKeySpec(s.CAKey)
+	if err != nil {
+		return nil, fmt.Errorf("ca_key: %w", err)
+	}
+	caFile := CAKeysFile(s)
+	if !path.IsAbs(caFile) || strings.ContainsAny(caFile, " \t\"") {
+		return nil, fmt.Errorf("ca_keys_file %q must be an absolute path without spaces", caFile)
+	}
+
+	// the CA keys file: add what is missing, keep everything else
+	data, err := fs.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", caFile, err)
+	}
+	f := authkeys.Parse(data)
+	var results []KeyResult
+	changed := false
+	for _, ca := range cas {
+		r := KeyResult{Source: ca.Source, Fingerprint: ca.Key.Fingerprint(), Status: KeyPresent}
+		if !f.Contains(ca.Key) {
+			f.Append(ca.Key.Render(nil))
+			r.Status = KeyAdded
+			changed = true
+		}
+		results = append(results, r)
+	}
+	if changed {
+		if err := fs.MkdirAll(path.Dir(caFile), 0o755); err != nil {
+			return markFailed(results, err), fmt.Errorf("create %s: %w", path.Dir(caFile), err)
+		}
+		if err := fs.WriteFile(caFile, f.Bytes(), 0o644); err != nil {
+			return markFailed(results, err), fmt.Errorf("write %s: %w", caFile, err)
+		}
+	}
+
+	// sshd_config: the first TrustedUserCAKeys sshd reads must be caFile
+	current, found, err := sshdConfigLookup(fs, sshdConfigPath, "TrustedUserCAKeys", 0)
+	if err != nil {
+		return results, err
+	}
+	if found && len(current) == 1 && current[0] == caFile {
+		return append(results, KeyResult{Source: sshdConfigPath, Status: KeyUnchanged}), nil
+	}
+	old, err := fs.ReadFile(sshdConfigPath)
+	if err != nil {
+		return results, fmt.Errorf("read %s: %w", sshdConfigPath, err)
+	}
+	if old == nil {
+		return results, fmt.Errorf("%s not found", sshdConfigPath)
+	}
+	ts := time.Now().UTC().Format(backupTimeFormat)
+	if err := fs.Copy(sshdConfigPath, sshdConfigPath+".bak."+ts); err != nil {
+		return results, fmt.Errorf("backup %s: %w", sshdConfigPath, err)
+	}
+	if err := fs.WriteFile(sshdConfigPath, setSSHDGlobal(old, "TrustedUserCAKeys", caFile), 0o644); err != nil {
+		return results, fmt.Errorf("write %s: %w", sshdConfigPath, err)
+	}
+
+	if out, err := h.run(sshdValidateScript); err != nil {
+		verr := fmt.Errorf("%v: %s", err, strings.TrimSpace(out))
+		results = append(results, KeyResult{Source: "sshd -t", Status: KeyFailed, Err: verr})
+		if rerr := fs.WriteFile(sshdConfigPath, old, 0o644); rerr != nil {
+			return results, fmt.Errorf("sshd -t rejected %s and restoring it failed (backup %s.bak.%s): %w", sshdConfigPath, sshdConfigPath, ts, rerr)
+		}
+		results = append(results, KeyResult{Source: sshdConfigPath, Status: SSHDRestored})
+		return results, fmt.Errorf("sshd -t rejected the new %s; previous version restored, sshd not reloaded", sshdConfigPath)
+	}
+	results = append(results, KeyResult{Source: sshdConfigPath, Status: KeyUpdated})
+
+	if out, err := h.run(sshdReloadScript); err != nil {
+		return results, fmt.Errorf("reload sshd: %v: %s", err, strings.TrimSpace(out))
+	}
+	return append(results, KeyResult{Source: "sshd", Status: SSHDReloaded}), nil
+}
+
+// CAKeysFile returns the server's ca_keys_file, or DefaultCAKeysFile when unset.
+func CAKeysFile(s config.Server) string {
+	if f := strings.TrimSpace(s.CAKeysFile); f != "" {
+		return f
+	}
+	return DefaultCAKeysFile
+}
+
+// setSSHDGlobal returns sshd_config data with "keyword value" as its first line, so that it wins
+// over any later or included setting (sshd uses the first value it reads). The line set by an
+// earlier run is dropped; other global occurrences of keyword are commented out; Match blocks
+// are left alone.
+func setSSHDGlobal(data []byte, keyword, value string) []byte {
+	const marker = "# set by sync-ssh-id"
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n%s %s\n", marker, keyword, value)
+	inMatch, afterMarker := false, false
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == marker {
+			afterMarker = true
+			continue
+		}
+		kw, args := splitSSHDConfigLine(line)
+		if afterMarker {
+			afterMarker = false
+			if !inMatch && strings.EqualFold(kw, keyword) {
+				continue // set by an earlier run; replaced by the first line
+			}
+		}
+		switch {
+		case strings.EqualFold(kw, "Match"):
+			inMatch = !(len(args) == 1 && strings.EqualFold(args[0], "all"))
+		case !inMatch && strings.EqualFold(kw, keyword):
+			line = "# " + line + " # replaced by sync-ssh-id"
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,69 @@
+package ops
+
+import "testing"
+
+func TestSetSSHDGlobal(t *testing.T) {
+	const set = "# set by sync-ssh-id\nTrustedUserCAKeys /etc/ssh/ca.pub\n"
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "fresh config",
+			in:   "Port 22\nUsePAM yes\n",
+			want: set + "Port 22\nUsePAM yes\n",
+		},
+		{
+			name: "empty config",
+			in:   "",
+			want: set,
+		},
+		{
+			name: "earlier setting commented out",
+			in:   "Port 22\nTrustedUserCAKeys /etc/ssh/old.pub\n",
+			want: set + "Port 22\n# TrustedUserCAKeys /etc/ssh/old.pub # replaced by sync-ssh-id\n",
+		},
+		{
+			name: "keyword case-insensitive",
+			in:   "trustedusercakeys /etc/ssh/old.pub\nTRUSTEDUSERCAKEYS=/etc/ssh/old2.pub\n",
+			want: set + "# trustedusercakeys /etc/ssh/old.pub # replaced by sync-ssh-id\n# TRUSTEDUSERCAKEYS=/etc/ssh/old2.pub # replaced by sync-ssh-id\n",
+		},
+		{
+			name: "re-run on managed output",
+			in:   set + "Port 22\n# TrustedUserCAKeys /etc/ssh/old.pub # replaced by sync-ssh-id\n",
+			want: set + "Port 22\n# TrustedUserCAKeys /etc/ssh/old.pub # replaced by sync-ssh-id\n",
+		},
+		{
+			name: "re-run with another value",
+			in:   "# set by sync-ssh-id\nTrustedUserCAKeys /etc/ssh/previous.pub\nPort 22\n",
+			want: set + "Port 22\n",
+		},
+		{
+			name: "marker followed by something else",
+			in:   "# set by sync-ssh-id\nPort 22\n",
+			want: set + "Port 22\n",
+		},
+		{
+			name: "match blocks left alone",
+			in:   "Match User ci\n  TrustedUserCAKeys /etc/ssh/ci.pub\nMatch all\nTrustedUserCAKeys /etc/ssh/old.pub\n",
+			want: set + "Match User ci\n  TrustedUserCAKeys /etc/ssh/ci.pub\nMatch all\n# TrustedUserCAKeys /etc/ssh/old.pub # replaced by sync-ssh-id\n",
+		},
+		{
+			name: "match all case-insensitive",
+			in:   "match group admins\nTrustedUserCAKeys /etc/ssh/admins.pub\nMATCH ALL\ntrustedusercakeys /etc/ssh/old.pub\n",
+			want: set + "match group admins\nTrustedUserCAKeys /etc/ssh/admins.pub\nMATCH ALL\n# trustedusercakeys /etc/ssh/old.pub # replaced by sync-ssh-id\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(setSSHDGlobal([]byte(tt.in), "TrustedUserCAKeys", "/etc/ssh/ca.pub"))
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+			if again := string(setSSHDGlobal([]byte(got), "TrustedUserCAKeys", "/etc/ssh/ca.pub")); again != got {
+				t.Errorf("not idempotent; second run gave\n%s", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package ops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/authkeys"
+)
+
+const (
+	urlFetchTimeout  = 15 * time.Second // one request to a keys endpoint
+	maxKeysResponse  = 1 << 20          // larger responses are rejected
+	urlCacheDirName  = "sync-ssh-id/keys"
+	urlCacheFileMode = 0o600
+)
+
+// urlKeys is one keys endpoint's answer, shared by every host of a run.
+type urlKeys struct {
+	once  sync.Once
+	keys  []authkeys.Entry
+	stale string // why the cached copy was used instead of a fresh one ("" = fetched now)
+	err   error
+}
+
+// isKeysURL reports whether src names a keys endpoint rather than a file.
+func isKeysURL(src string) bool {
+	lower := strings.ToLower(src)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")
+}
+
+// loadURLKeys returns the keys served at rawURL, an endpoint answering with authorized_keys
+// lines such as https://git.corp/alice.keys. Each URL is fetched once per KeyManager. If the
+// endpoint cannot be reached or serves something that is not a key list, the copy saved by the
+// last successful fetch is used while it is younger than URLCacheMaxAge. With pins, every key
+// served must have one of those fingerprints, otherwise none of them is used.
+func (k *KeyManager) loadURLKeys(rawURL string, pins []string) ([]LocalKey, error) {
+	k.urlMu.Lock()
+	if k.urlKeys == nil {
+		k.urlKeys = map[string]*urlKeys{}
+	}
+	u, ok := k.urlKeys[rawURL]
+	if !ok {
+		u = &urlKeys{}
+		k.urlKeys[rawURL] = u
+	}
+	k.urlMu.Unlock()
+	u.once.Do(func() { u.keys, u.stale, u.err = k.fetchURLKeys(rawURL) })
+	if u.err != nil {
+		return nil, u.err
+	}
+
+	allowed := map[string]bool{}
+	for _, p := range pins {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, "SHA256:") {
+			return nil, fmt.Errorf("fingerprint %q: want SHA256:... as printed by ssh-keygen -l", p)
+		}
+		allowed[strings.TrimRight(p, "=")] = true
+	}
+	src := rawURL
+	if u.stale != "" {
+		src += " (" + u.stale + ")"
+	}
+	var out []LocalKey
+	for _, e := range u.keys {
+		if len(allowed) > 0 && !allowed[e.Fingerprint()] {
+			return nil, fmt.Errorf("%s serves %s, which is not in fingerprints; not using any of its keys", rawURL, e.Fingerprint())
+		}
+		if e.Comment == "" {
+			e.Comment = rawURL // keys endpoints usually drop comments; keep the key traceable
+		}
+		out = append(out, LocalKey{Source: src, Key: e})
+	}
+	return out, nil
+}
+
+// fetchURLKeys downloads and parses the key list at rawURL, saving it to the cache, or falls
+// back to the cached copy.
+func (k *KeyManager) fetchURLKeys(rawURL string) ([]authkeys.Entry, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, "", fmt.Errorf("invalid key url %q", rawURL)
+	}
+	if u.Scheme != "https" {
+		return nil, "", fmt.Errorf("%s: key urls must use https", rawURL)
+	}
+
+	data, err := httpGetKeys(rawURL, k.urlTransport)
+	var keys []authkeys.Entry
+	if err == nil {
+		if keys, err = parseURLKeys(data); err == nil {
+			_ = writeURLCache(rawURL, data) // best effort: the cache only helps when the endpoint fails later
+			return keys, "", nil
+		}
+	}
+
+	cached, age, cerr := readURLCache(rawURL, k.URLCacheMaxAge)
+	if cerr != nil {
+		return nil, "", fmt.Errorf("%s: %w (no usable cache: %v)", rawURL, err, cerr)
+	}
+	if keys, cerr = parseURLKeys(cached); cerr != nil {
+		return nil, "", fmt.Errorf("%s: %w (cache: %v)", rawURL, err, cerr)
+	}
+	return keys, fmt.Sprintf("cached %s ago: %v", age.Round(time.Second), err), nil
+}
+
+// httpGetKeys fetches a keys endpoint, refusing redirects away from https and oversized answers.
+func httpGetKeys(rawURL string, transport http.RoundTripper) ([]byte, error) {
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   urlFetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to %s refused", req.URL.Redacted())
+			}
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "sync-ssh-id")
+	req.Header.Set("Accept", "text/plain")
+	resp, err := client.Do(req)
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return nil, ue.Err // the URL is already in every message about this source
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeysResponse+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeysResponse {
+		return nil, fmt.Errorf("response larger than %d bytes", maxKeysResponse)
+	}
+	return data, nil
+}
+
+// parseURLKeys parses a keys endpoint answer. Every non-blank, non-comment line must be a
+// plain key: options such as command= are not taken from a URL. An answer without keys is an
+// error, not an empty list: it is more often a proxy or account glitch than a person with no
+// keys, and in exclusive mode it would prune that person's keys everywhere.
+func parseURLKeys(data []byte) ([]authkeys.Entry, error) {
+	var out []authkeys.Entry
+	for i, l := range strings.Split(string(data), "\n") {
+		e, err := authkeys.ParseLine(l)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: not a public key", i+1)
+		}
+		if !e.IsKey() {
+			continue
+		}
+		if len(e.Options) > 0 {
+			return nil, fmt.Errorf("line %d: keys served by a url must not carry options", i+1)
+		}
+		e.Line = i + 1
+		out = append(out, e)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no keys served")
+	}
+	return out, nil
+}
+
+// urlCachePath returns where the key list of rawURL is cached: one file per URL in the user cache directory.
+func urlCachePath(rawURL string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(dir, filepath.FromSlash(urlCacheDirName), hex.EncodeToString(sum[:16])+".keys"), nil
+}
+
+func writeURLCache(rawURL string, data []byte) error {
+	path, err := urlCachePath(rawURL)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keys.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(urlCacheFileMode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readURLCache returns the cached key list of rawURL and its age, if it is not older than maxAge.
+func readURLCache(rawURL string, maxAge time.Duration) ([]byte, time.Duration, error) {
+	if maxAge <= 0 {
+		return nil, 0, errors.New("cache disabled")
+	}
+	path, err := urlCachePath(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, errors.New("never fetched")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	age := time.Since(fi.ModTime())
+	if age > maxAge {
+		return nil, 0, fmt.Errorf("cached copy is %s old", age.Round(time.Minute))
+	}
+	data, err := os.ReadFile(path)
+	return data, age, err
+}
@@ -0,0 +1,161 @@
+package ops
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keysServer serves key lists over TLS and returns a KeyManager that trusts it, with the
+// url: cache in a fresh temporary directory.
+func keysServer(t *testing.T, answers map[string]string) (*httptest.Server, *KeyManager) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(aliceKey + " alice\n"))
+	}))
+	t.Cleanup(plain.Close)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/to-http":
+			http.Redirect(w, r, plain.URL+"/alice.keys", http.StatusFound)
+			return
+		case "/to-https":
+			http.Redirect(w, r, "/alice.keys", http.StatusFound)
+			return
+		}
+		body, ok := answers[r.URL.Path]
+		if !ok {
+			http.Error(w, "gone", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	k := NewKeyManager()
+	k.urlTransport = srv.Client().Transport
+	return srv, k
+}
+
+func TestLoadURLKeys(t *testing.T) {
+	aliceFP := localKey(t, aliceKey).Key.Fingerprint()
+	bobFP := localKey(t, bobKey).Key.Fingerprint()
+	answers := map[string]string{
+		"/alice.keys":   aliceKey + " alice\n",
+		"/team.keys":    "# team\n\n" + aliceKey + "\n" + bobKey + " bob@corp\n",
+		"/empty.keys":   "\n# nobody here\n",
+		"/options.keys": `command="/bin/sh" ` + aliceKey + " alice\n",
+		"/html.keys":    "<html>login</html>\n",
+	}
+	tests := []struct {
+		name     string
+		path     string
+		pins     []string
+		comments []string // comment of each key returned
+		err      string
+	}{
+		{name: "plain list", path: "/alice.keys", comments: []string{"alice"}},
+		{name: "comment falls back to the url", path: "/team.keys", comments: []string{"{url}", "bob@corp"}},
+		{name: "https redirect followed", path: "/to-https", comments: []string{"alice"}},
+		{name: "redirect to http refused", path: "/to-http", err: "redirect to http://"},
+		{name: "empty answer", path: "/empty.keys", err: "no keys served"},
+		{name: "options refused", path: "/options.keys", err: "line 1: keys served by a url must not carry options"},
+		{name: "not a key list", path: "/html.keys", err: "line 1: not a public key"},
+		{name: "server error", path: "/missing.keys", err: "HTTP 500"},
+		{name: "pins match", path: "/team.keys", pins: []string{aliceFP, " " + bobFP + "= "}, comments: []string{"{url}", "bob@corp"}},
+		{name: "unpinned key", path: "/team.keys", pins: []string{aliceFP}, err: "serves " + bobFP + ", which is not in fingerprints"},
+		{name: "malformed pin", path: "/alice.keys", pins: []string{"MD5:aa:bb"}, err: `fingerprint "MD5:aa:bb": want SHA256:`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, k := keysServer(t, answers)
+			rawURL := srv.URL + tt.path
+			keys, err := k.loadURLKeys(rawURL, tt.pins)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var comments []string
+			for _, key := range keys {
+				if key.Source != rawURL {
+					t.Errorf("source = %q, want %q", key.Source, rawURL)
+				}
+				comments = append(comments, strings.ReplaceAll(key.Key.Comment, rawURL, "{url}"))
+			}
+			if strings.Join(comments, ",") != strings.Join(tt.comments, ",") {
+				t.Errorf("comments = %q, want %q", comments, tt.comments)
+			}
+		})
+	}
+}
+
+func TestLoadURLKeysHTTPSOnly(t *testing.T) {
+	for _, rawURL := range []string{"http://keys.example/alice.keys", "HTTP://keys.example/alice.keys", "https:///alice.keys"} {
+		_, err := NewKeyManager().loadURLKeys(rawURL, nil)
+		if err == nil {
+			t.Errorf("loadURLKeys(%q) succeeded", rawURL)
+		}
+	}
+}
+
+func TestLoadURLKeysCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		age    time.Duration // age of the cached copy when the endpoint fails
+		maxAge time.Duration
+		err    string
+	}{
+		{name: "fresh cache", age: time.Hour, maxAge: 24 * time.Hour},
+		{name: "cache too old", age: 48 * time.Hour, maxAge: 24 * time.Hour, err: "no usable cache: cached copy is 48h0m0s old"},
+		{name: "cache disabled", age: time.Minute, maxAge: 0, err: "no usable cache: cache disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, k := keysServer(t, map[string]string{"/alice.keys": aliceKey + " alice\n"})
+			rawURL := srv.URL + "/alice.keys"
+			if _, err := k.loadURLKeys(rawURL, nil); err != nil {
+				t.Fatal(err)
+			}
+			path, err := urlCachePath(rawURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != urlCacheFileMode {
+				t.Fatalf("cache file: %v, %v", fi, err)
+			}
+			mtime := time.Now().Add(-tt.age)
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			srv.Close() // the endpoint is now unreachable
+			later := NewKeyManager()
+			later.urlTransport = k.urlTransport
+			later.URLCacheMaxAge = tt.maxAge
+			keys, err := later.loadURLKeys(rawURL, nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 1 || keys[0].Key.Comment != "alice" {
+				t.Fatalf("keys = %+v", keys)
+			}
+			if want := rawURL + " (cached 1h0m0s ago: "; !strings.HasPrefix(keys[0].Source, want) {
+				t.Errorf("source = %q, want prefix %q", keys[0].Source, want)
+			}
+		})
+	}
+}
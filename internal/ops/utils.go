@@ -8,23 +8,7 @@ import (
 
 // escapeForSingleQuotes safe-escapes string for embedding as a single-quoted shell arg
 func escapeForSingleQuotes(s string) string {
-	if s == "" {
-		return "''"
-	}
-	parts := strings.Split(s, "'")
-	if len(parts) == 1 {
-		return "'" + s + "'"
-	}
-	var b strings.Builder
-	for i, p := range parts {
-		if p != "" {
-			b.WriteString("'" + p + "'")
-		}
-		if i != len(parts)-1 {
-			b.WriteString(`'"'"'`)
-		}
-	}
-	return b.String()
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
 }
 
 func addrAndPort(s config.Server) (host string, port string) {
@@ -36,3 +20,15 @@ func addrAndPort(s config.Server) (host string, port string) {
 	port = strings.TrimSpace(s.Port)
 	return
 }
+
+// shellPath quotes a remote path for the shell while keeping a leading "~/"
+// unquoted so the remote shell still expands it to the login user's home.
+func shellPath(p string) string {
+	if p == "~" {
+		return p
+	}
+	if strings.HasPrefix(p, "~/") {
+		return "~/" + escapeForSingleQuotes(p[2:])
+	}
+	return escapeForSingleQuotes(p)
+}
@@ -0,0 +1,117 @@
+package ops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/thineshsubramani/sync-ssh-id/internal/config"
+)
+
+var (
+	// errNotAccepted wraps a dial failure when the server refused the key being verified.
+	errNotAccepted = errors.New("key not accepted")
+	// errNoPrivateKey is returned when no local private key matches the key being verified.
+	errNoPrivateKey = errors.New("no private key found")
+)
+
+// signerFor finds a local signer whose public key is pub: first the private key next to source
+// (source without ".pub"), then the default ~/.ssh identities, then the ssh-agent.
+// The returned func releases the agent connection and must be called when the signer is no longer used.
+func signerFor(pub ssh.PublicKey, source string) (ssh.Signer, func(), error) {
+	want := pub.Marshal()
+	noop := func() {}
+
+	var cands []string
+	if p, ok := strings.CutSuffix(source, ".pub"); ok {
+		cands = append(cands, p)
+	}
+	if usr, err := user.Current(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			cands = append(cands, filepath.Join(usr.HomeDir, ".ssh", name))
+		}
+	}
+	for _, p := range cands {
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		if signer, err := readPrivateKeySigner(p); err == nil && bytes.Equal(signer.PublicKey().Marshal(), want) {
+			return signer, noop, nil
+		}
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			signers, err := agent.NewClient(conn).Signers()
+			if err == nil {
+				for _, signer := range signers {
+					if bytes.Equal(signer.PublicKey().Marshal(), want) {
+						return signer, func() { _ = conn.Close() }, nil
+					}
+				}
+			}
+			_ = conn.Close()
+		}
+	}
+	return nil, noop, fmt.Errorf("%w for %s (looked next to %s, in ~/.ssh and in ssh-agent)", errNoPrivateKey, ssh.FingerprintSHA256(pub), source)
+}
+
+// verifyLogin opens a fresh connection to s that authenticates with key alone, proving sshd accepts it.
+// The login is made as target_user when one is set, since that is whose file the key went into.
+// A refused login is reported as errNotAccepted, a missing private key as errNoPrivateKey.
+func (k *KeyManager) verifyLogin(s config.Server, key LocalKey) error {
+	if t := strings.TrimSpace(s.TargetUser); t != "" {
+		s.User = t
+	}
+	signer, release, err := signerFor(key.Key.Key, key.Source)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	client, err := k.dialWithAuth(s, []ssh.AuthMethod{ssh.PublicKeys(signer)})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotAccepted, err)
+	}
+	return client.Close()
+}
+
+// verifyKeys appends a verified / not-accepted result for every key that is now deployed
+// (added, present, updated or unchanged). Keys whose private half is not available locally
+// (e.g. a teammate's key) are reported as unverifiable without failing the host.
+func (k *KeyManager) verifyKeys(s config.Server, keys []LocalKey, results []KeyResult) []KeyResult {
+	deployed := map[string]bool{}
+	for _, r := range results {
+		switch r.Status {
+		case KeyAdded, KeyPresent, KeyUpdated, KeyUnchanged:
+			deployed[r.Fingerprint] = true
+		}
+	}
+	for _, key := range keys {
+		fp := key.Key.Fingerprint()
+		if !deployed[fp] {
+			continue
+		}
+		r := KeyResult{Source: key.Source, Fingerprint: fp, Status: KeyVerified}
+		if err := k.verifyLogin(s, key); err != nil {
+			switch {
+			case errors.Is(err, errNotAccepted):
+				r.Status, r.Err = KeyNotAccepted, err
+			case errors.Is(err, errNoPrivateKey):
+				r.Status = KeyUnverifiable
+			default:
+				r.Status, r.Err = KeyFailed, err
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
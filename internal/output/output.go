@@ -14,6 +14,7 @@ const (
 	StatusStart Status = "START"
 	StatusOK    Status = "OK"
 	StatusError Status = "ERROR"
+	StatusDrift Status = "DRIFT" // host differs from the inventory (check action)
 )
 
 // ANSI colors
@@ -33,35 +34,100 @@ type LogEntry struct {
 	RemotePath string
 	Status     Status
 	Err        error
+	Detail     string    // short per-key outcome, e.g. "added" or "present"
+	Time       time.Time // when the entry was recorded; zero means "now"
 }
 
-func OK(h config.Server, remotePath string) {
-	Print(LogEntry{
+func newEntry(h config.Server, remotePath string, status Status, err error) LogEntry {
+	return LogEntry{
 		Host:       h.Host,
 		Action:     h.Action,
 		User:       h.User,
 		Pass:       h.Pass,
 		PubKey:     h.PublicKey,
 		RemotePath: remotePath,
-		Status:     StatusOK,
-	})
+		Status:     status,
+		Err:        err,
+		Time:       time.Now(),
+	}
+}
+
+func OK(h config.Server, remotePath string) {
+	Print(newEntry(h, remotePath, StatusOK, nil))
 }
 
 func Error(h config.Server, remotePath string, err error) {
-	Print(LogEntry{
-		Host:       h.Host,
-		Action:     h.Action,
-		User:       h.User,
-		Pass:       h.Pass,
-		PubKey:     h.PublicKey,
-		RemotePath: remotePath,
-		Status:     StatusError,
-		Err:        err,
-	})
+	Print(newEntry(h, remotePath, StatusError, err))
+}
+
+// Buffer collects entries for one host so that concurrent workers can
+// flush their lines in inventory order without interleaving.
+type Buffer struct {
+	entries []LogEntry
+}
+
+func (b *Buffer) OK(h config.Server, remotePath string) {
+	b.entries = append(b.entries, newEntry(h, remotePath, StatusOK, nil))
+}
+
+func (b *Buffer) Error(h config.Server, remotePath string, err error) {
+	b.entries = append(b.entries, newEntry(h, remotePath, StatusError, err))
+}
+
+// Key records the outcome for one public key of a host; a non-nil err marks it as an error.
+func (b *Buffer) Key(h config.Server, remotePath, pubKey, detail string, err error) {
+	status := StatusOK
+	if err != nil {
+		status = StatusError
+	}
+	e := newEntry(h, remotePath, status, err)
+	e.PubKey = pubKey
+	e.Detail = detail
+	b.entries = append(b.entries, e)
+}
+
+// Drift records a key whose remote state differs from the inventory, e.g. "missing".
+func (b *Buffer) Drift(h config.Server, remotePath, pubKey, detail string) {
+	e := newEntry(h, remotePath, StatusDrift, nil)
+	e.PubKey = pubKey
+	e.Detail = detail
+	b.entries = append(b.entries, e)
+}
+
+// Drifted reports whether any buffered entry is a drift.
+func (b *Buffer) Drifted() bool {
+	for _, e := range b.entries {
+		if e.Status == StatusDrift {
+			return true
+		}
+	}
+	return false
+}
+
+// Failed reports whether any buffered entry is an error.
+func (b *Buffer) Failed() bool {
+	for _, e := range b.entries {
+		if e.Status == StatusError {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush prints all buffered entries and empties the buffer.
+func (b *Buffer) Flush() {
+	for _, e := range b.entries {
+		Print(e)
+	}
+	b.entries = nil
 }
 
 func Print(entry LogEntry) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	ts := entry.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	timestamp := ts.Format("2006-01-02 15:04:05")
 	passMasked := util.Mask(entry.Pass)
 
 	// Colorize status
@@ -71,10 +137,16 @@ func Print(entry LogEntry) {
 		statusColored = colorGreen + "Success" + colorReset
 	case StatusError:
 		statusColored = colorRed + "ERROR" + colorReset
+	case StatusDrift:
+		statusColored = colorYellow + "DRIFT" + colorReset
 	case StatusStart:
 		statusColored = colorYellow + "START" + colorReset
 	}
 
+	if entry.Detail != "" {
+		statusColored += " [" + entry.Detail + "]"
+	}
+
 	// Build log line
 	if entry.Status == StatusError && entry.Err != nil {
 		log.Printf("%s  %-15s  %-8s  %-10s  %-10s  %-30s  %-30s  %s (%v)",
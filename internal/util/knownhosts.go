@@ -1,27 +1,109 @@
 package util
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
 	"os"
+	"path/filepath"
 	"strings"
+
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
-// ResetKnownHost removes lines matching host from known_hosts
-func ResetKnownHost(host string) error {
+// ResetKnownHost removes known_hosts lines for the given addresses ("host" or "host:port").
+// Addresses are compared exactly, in OpenSSH's normalized form ("host" for port 22,
+// "[host]:port" otherwise), against plain and hashed host patterns. Marker lines
+// (@cert-authority, @revoked) are kept. It returns how many lines were removed.
+func ResetKnownHost(addrs ...string) (int, error) {
 	path := KnownHostsPath()
 	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
 	if err != nil {
-		return err
+		return 0, err
 	}
-	lines := strings.Split(string(b), "\n")
-	var keep []string
+
+	want := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if strings.TrimSpace(a) != "" {
+			want = append(want, knownhosts.Normalize(a))
+		}
+	}
+
+	lines := strings.SplitAfter(string(b), "\n")
+	var keep strings.Builder
+	removed := 0
 	for _, l := range lines {
-		if l == "" {
+		if knownHostLineMatches(l, want) {
+			removed++
 			continue
 		}
-		if strings.Contains(l, host) {
-			continue
+		keep.WriteString(l)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	mode := os.FileMode(0o600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".known_hosts.*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(keep.String()); err != nil {
+		_ = tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// knownHostLineMatches reports whether a known_hosts line is for one of the normalized hosts.
+func knownHostLineMatches(line string, hosts []string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+		return false
+	}
+	for _, pat := range strings.Split(fields[0], ",") {
+		for _, h := range hosts {
+			if pat == h || hashedHostMatches(pat, h) {
+				return true
+			}
 		}
-		keep = append(keep, l)
 	}
-	return os.WriteFile(path, []byte(strings.Join(keep, "\n")), 0644)
+	return false
+}
+
+// hashedHostMatches checks a "|1|salt|hash" pattern (HashKnownHosts yes) against host.
+func hashedHostMatches(pat, host string) bool {
+	parts := strings.Split(pat, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	sum, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), sum)
 }
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const testHostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMnJHrlG6MZoTr+wScNAKnDaPBdTDg49vboB4yU+Pik6"
+
+// Hashed with ssh-keygen -H from "example.com" and "[example.com]:2222".
+const (
+	hashedExample     = "|1|bWJtZQjK1MhqAeZgyU76+YXpd5M=|RvVp5am2nyJsJ3/2huBmsdH5jp4="
+	hashedExample2222 = "|1|Nwv4JNPdSgAXq3indP31TdRnJJg=|uiaZxKF4+7XPabdrVoeRxTCX8bo="
+)
+
+func TestHashedHostMatches(t *testing.T) {
+	tests := []struct {
+		pat  string
+		host string
+		want bool
+	}{
+		{hashedExample, "example.com", true},
+		{hashedExample, "example.org", false},
+		{hashedExample, "[example.com]:22", false}, // callers normalize first
+		{hashedExample2222, "[example.com]:2222", true},
+		{hashedExample2222, "example.com", false},
+		{hashedExample2222, "[example.com]:22", false},
+		{knownhosts.HashHostname(knownhosts.Normalize("10.0.0.5:2200")), "[10.0.0.5]:2200", true},
+		{knownhosts.HashHostname("10.0.0.5"), "10.0.0.5", true},
+		{"example.com", "example.com", false}, // not hashed
+		{"|2|bWJtZQjK1MhqAeZgyU76+YXpd5M=|RvVp5am2nyJsJ3/2huBmsdH5jp4=", "example.com", false},
+		{"|1|not base64!|RvVp5am2nyJsJ3/2huBmsdH5jp4=", "example.com", false},
+		{"|1|bWJtZQjK1MhqAeZgyU76+YXpd5M=", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := hashedHostMatches(tt.pat, tt.host); got != tt.want {
+			t.Errorf("hashedHostMatches(%q, %q) = %v, want %v", tt.pat, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestKnownHostLineMatches(t *testing.T) {
+	hosts := []string{knownhosts.Normalize("example.com:22"), knownhosts.Normalize("example.com:2222")}
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"example.com " + testHostKey + "\n", true},
+		{"[example.com]:2222 " + testHostKey, true},
+		{"[example.com]:2200 " + testHostKey, false},
+		{"other.com,example.com,10.0.0.1 " + testHostKey, true},
+		{"example.com.evil " + testHostKey, false},
+		{hashedExample + " " + testHostKey, true},
+		{hashedExample2222 + " " + testHostKey, true},
+		{"@cert-authority example.com " + testHostKey, false},
+		{"@revoked " + hashedExample + " " + testHostKey, false},
+		{"# example.com " + testHostKey, false},
+		{"example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := knownHostLineMatches(tt.line, hosts); got != tt.want {
+			t.Errorf("knownHostLineMatches(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
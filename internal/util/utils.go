@@ -7,20 +7,38 @@ import (
 	"strings"
 )
 
+// DefaultPubKeyNames are the public keys DetectDefaultPubKey looks for in ~/.ssh, in OpenSSH's order of preference.
+var DefaultPubKeyNames = []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"}
+
+// DetectDefaultPubKey returns PUB_KEY_PATH if set, otherwise the first of DefaultPubKeyNames
+// that exists in ~/.ssh. If there is none it returns ~/.ssh/id_ed25519.pub, the key
+// --generate-if-missing creates.
 func DetectDefaultPubKey() string {
 	if v := os.Getenv("PUB_KEY_PATH"); v != "" {
 		return v
 	}
+	dir := SSHDir()
+	for _, name := range DefaultPubKeyNames {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return filepath.Join(dir, DefaultPubKeyNames[0])
+}
+
+// SSHDir returns the current user's ~/.ssh directory.
+func SSHDir() string {
 	if runtime.GOOS == "windows" {
 		if up := os.Getenv("USERPROFILE"); up != "" {
-			return filepath.Join(up, ".ssh", "id_rsa.pub")
+			return filepath.Join(up, ".ssh")
 		}
-		return filepath.Join(os.Getenv("HOMEDRIVE")+os.Getenv("HOMEPATH"), ".ssh", "id_rsa.pub")
+		return filepath.Join(os.Getenv("HOMEDRIVE")+os.Getenv("HOMEPATH"), ".ssh")
 	}
 	if u, err := os.UserHomeDir(); err == nil {
-		return filepath.Join(u, ".ssh", "id_rsa.pub")
+		return filepath.Join(u, ".ssh")
 	}
-	return "./.ssh/id_rsa.pub"
+	return "./.ssh"
 }
 
 func Mask(s string) string {